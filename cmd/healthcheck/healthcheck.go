@@ -1,7 +1,8 @@
 /*
 Healthcheck is a simple program that sends an HTTP request to the local host (self) to a configured port number.
 It's used in environment where you need a simple probe for health checks (e.g., an empty container in docker).
-The probe URL is http://localhost:3000/liveness . Only the port can be changed.
+The probe URL is http://localhost:3000/liveness (or http://localhost:3000/readiness if -readiness is specified). Only the
+port can be changed.
 
 Usage:
 
@@ -12,6 +13,9 @@ The flags are:
 	-port <1-65535>
 		Change the port where the request is sent.
 
+	-readiness
+		Probe the readiness endpoint (database, schema and storage checks) instead of the liveness one.
+
 Return values (exit codes):
 
 	0
@@ -31,10 +35,16 @@ import (
 
 func main() {
 	var port = flag.Int("port", 3000, "HTTP port for healthcheck")
+	var readiness = flag.Bool("readiness", false, "Probe readiness instead of liveness")
 
 	flag.Parse()
 
-	res, err := http.Get(fmt.Sprintf("http://localhost:%d/liveness", *port))
+	probe := "liveness"
+	if *readiness {
+		probe = "readiness"
+	}
+
+	res, err := http.Get(fmt.Sprintf("http://localhost:%d/%s", *port, probe))
 	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
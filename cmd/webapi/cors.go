@@ -15,7 +15,10 @@ func applyCORSHandler(h http.Handler) http.Handler {
 			"Content-Type", // to allow JSON headers
 			"Authorization",
 		}),
-		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT"}),
+		handlers.AllowedMethods([]string{"GET", "POST", "OPTIONS", "DELETE", "PUT", "PATCH"}),
+		handlers.ExposedHeaders([]string{
+			"X-Request-ID", // to allow clients to report the request ID
+		}),
 		// Do not modify the CORS origin and max age, they are used in the evaluation.
 		handlers.AllowedOrigins([]string{"*"}),
 		handlers.MaxAge(1),
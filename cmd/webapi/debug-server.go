@@ -0,0 +1,26 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"wasatext/service/metrics"
+)
+
+// debugHandler returns the handler for the debug web server. It exposes application metrics in the Prometheus text
+// format (/metrics), debug variables (/debug/vars) and the profiler (/debug/pprof/).
+// The debug server should never be reachable from the public network.
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
@@ -23,6 +23,40 @@ type WebAPIConfiguration struct {
 		ReadTimeout     time.Duration `conf:"default:5s"`
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
+		DrainDelay      time.Duration `conf:"default:5s"`
+		BehindProxy     bool
+	}
+	Log struct {
+		Level            string `conf:"default:info"`
+		MethodName       bool
+		JSON             bool
+		Destination      string `conf:"default:stdout"`
+		File             string `conf:"default:/tmp/wasatext.log"`
+		CombinedToStdout bool
+		MaxSizeMB        int `conf:"default:100"`
+		MaxBackups       int `conf:"default:5"`
+	}
+	Auth struct {
+		LegacyLogin bool
+	}
+	OIDC struct {
+		Issuer       string
+		ClientID     string
+		ClientSecret string `conf:"mask"`
+		RedirectURL  string
+		Scopes       []string `conf:"default:openid;profile;email"`
+		PostLoginURL string
+	}
+	RateLimit struct {
+		LoginPerMinute    float64 `conf:"default:10"`
+		LoginBurst        int     `conf:"default:5"`
+		MessagesPerMinute float64 `conf:"default:120"`
+		MessagesBurst     int     `conf:"default:30"`
+		UploadsPerMinute  float64 `conf:"default:20"`
+		UploadsBurst      int     `conf:"default:5"`
+	}
+	Chat struct {
+		MaxPinnedMessages int `conf:"default:10"`
 	}
 	Debug bool
 	DB    struct {
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newLogger creates the logger using the `log` section of the configuration:
+//   - level: one of the logrus levels (panic, fatal, error, warning, info, debug, trace). `debug: true` forces debug
+//   - json: use the JSON formatter instead of the text one
+//   - methodname: add the calling method to each log entry
+//   - destination: stdout, stderr or file
+//   - file: log file path (when destination is file), rotated when it grows over maxsizemb, keeping maxbackups files
+//   - combinedtostdout: when logging to file, write every entry to stdout too
+//
+// When logging to file, the returned io.Closer should be closed when the logger is no longer used (it's nil otherwise).
+func newLogger(cfg WebAPIConfiguration) (*logrus.Logger, io.Closer, error) {
+	logger := logrus.New()
+
+	level, err := logrus.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing log level: %w", err)
+	}
+	if cfg.Debug && level < logrus.DebugLevel {
+		level = logrus.DebugLevel
+	}
+	logger.SetLevel(level)
+
+	if cfg.Log.JSON {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	logger.SetReportCaller(cfg.Log.MethodName)
+
+	var closer io.Closer
+	switch strings.ToLower(cfg.Log.Destination) {
+	case "", "stdout":
+		logger.SetOutput(os.Stdout)
+	case "stderr":
+		logger.SetOutput(os.Stderr)
+	case "file":
+		file, err := newRotatingFile(cfg.Log.File, int64(cfg.Log.MaxSizeMB)*1024*1024, cfg.Log.MaxBackups)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening log file: %w", err)
+		}
+		closer = file
+		if cfg.Log.CombinedToStdout {
+			logger.SetOutput(io.MultiWriter(file, os.Stdout))
+		} else {
+			logger.SetOutput(file)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown log destination %q", cfg.Log.Destination)
+	}
+
+	return logger, closer, nil
+}
+
+// rotatingFile is an io.WriteCloser writing to a file which is rotated when its size exceeds maxSize bytes. Rotated
+// files are renamed with a numeric suffix (path.1 is the most recent), and only maxBackups of them are kept.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	fp   *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	fp, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := fp.Stat()
+	if err != nil {
+		_ = fp.Close()
+		return err
+	}
+	rf.fp = fp
+	rf.size = info.Size()
+	return nil
+}
+
+// rotate closes the current file, shifts the backups and opens a new, empty file
+func (rf *rotatingFile) rotate() error {
+	if err := rf.fp.Close(); err != nil {
+		return err
+	}
+
+	if rf.maxBackups <= 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return rf.open()
+	}
+
+	_ = os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating log file: %w", err)
+		}
+	}
+
+	n, err := rf.fp.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.fp.Close()
+}
@@ -10,6 +10,40 @@ Usage:
 
 Flags and configurations are handled automatically by the code in `load-configuration.go`.
 
+The configuration file (see `demo/config.yml`) has these sections:
+
+	log:
+		level: logging level (panic, fatal, error, warning, info, debug, trace; default info)
+		methodname: add the calling method name to each log entry
+		json: log in JSON format instead of text
+		destination: stdout (default), stderr or file
+		file: log file path when destination is file (rotated after maxsizemb MB, keeping maxbackups old files)
+		combinedtostdout: when destination is file, log to stdout too
+	web:
+		apihost, debughost: listen addresses for the API and the debug web servers
+		readtimeout, writetimeout, shutdowntimeout: timeouts for the API web server (real-time streams are closed
+			just before writetimeout, and clients reconnect)
+		draindelay: on shutdown, how long the readiness probe fails before the API server stops accepting requests,
+			so that load balancers stop routing traffic here (default 5s; 0 stops immediately)
+		behindproxy: trust the X-Forwarded-For header to find the client IP address (only behind a reverse proxy)
+	auth:
+		legacylogin: allow username-only logins for accounts without a passphrase (demo environments only)
+	oidc:
+		issuer: OpenID Connect provider https issuer URL (empty disables the OpenID Connect login)
+		clientid, clientsecret: client credentials registered on the provider (the secret is optional)
+		redirecturl: callback URL registered on the provider (https://<host>/session/oidc/callback)
+		scopes: requested scopes (default openid, profile, email)
+		postloginurl: web UI URL where the session is sent (in the fragment) after login; JSON response if empty
+	ratelimit:
+		loginperminute, loginburst: rate limit for logins per client IP address and per user; failed logins beyond it
+			slow down the next logins for the same username (0 disables it)
+		messagesperminute, messagesburst: rate limit for sending, forwarding and commenting messages
+		uploadsperminute, uploadsburst: rate limit for photo uploads
+	chat:
+		maxpinnedmessages: maximum number of pinned messages in each chat (default 10; 0 disables pinning)
+	db:
+		filename: SQLite database file path
+
 Return values (exit codes):
 
 	0
@@ -33,13 +67,15 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 	"wasatext/service/api"
 	"wasatext/service/database"
 	"wasatext/service/globaltime"
+	"wasatext/service/metrics"
+	"wasatext/service/oidc"
 
 	"github.com/ardanlabs/conf"
 	_ "github.com/mattn/go-sqlite3"
-	"github.com/sirupsen/logrus"
 )
 
 // main is the program entry point. The only purpose of this function is to call run() and set the exit code if there is
@@ -57,8 +93,9 @@ func main() {
 // * connects to any external resources (like databases, authenticators, etc.)
 // * creates an instance of the service/api package
 // * starts the principal web server (using the service/api.Router.Handler() for HTTP handlers)
+// * starts the debug web server (metrics, debug variables and profiler)
 // * waits for any termination event: SIGTERM signal (UNIX), non-recoverable server error, etc.
-// * closes the principal web server
+// * closes the principal web server and the debug web server
 func run() error {
 	rand.Seed(globaltime.Now().UnixNano())
 	// Load Configuration and defaults
@@ -71,19 +108,22 @@ func run() error {
 	}
 
 	// Init logging
-	logger := logrus.New()
-	logger.SetOutput(os.Stdout)
-	if cfg.Debug {
-		logger.SetLevel(logrus.DebugLevel)
-	} else {
-		logger.SetLevel(logrus.InfoLevel)
+	logger, logCloser, err := newLogger(cfg)
+	if err != nil {
+		return fmt.Errorf("configuring logger: %w", err)
+	}
+	if logCloser != nil {
+		defer func() {
+			_ = logCloser.Close()
+		}()
 	}
 
 	logger.Infof("application initializing")
 
 	// Start Database
 	logger.Println("initializing database support")
-	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
+	// SQLite enforces the foreign keys (and their cascades) only if asked to, on each connection
+	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename+"?_foreign_keys=on")
 	if err != nil {
 		logger.WithError(err).Error("error opening SQLite DB")
 		return fmt.Errorf("opening SQLite: %w", err)
@@ -110,10 +150,45 @@ func run() error {
 	// buffered channel so the goroutine can exit if we don't collect this error.
 	serverErrors := make(chan error, 1)
 
+	// OpenID Connect login is enabled only if an issuer is configured
+	var oidcProvider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		logger.Infof("OpenID Connect login enabled for %s", cfg.OIDC.Issuer)
+		oidcProvider, err = oidc.New(oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+			Scopes:       cfg.OIDC.Scopes,
+		})
+		if err != nil {
+			logger.WithError(err).Error("error configuring OpenID Connect")
+			return fmt.Errorf("configuring OpenID Connect: %w", err)
+		}
+	}
+
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:           logger,
+		Database:         db,
+		BehindProxy:      cfg.Web.BehindProxy,
+		LegacyLogin:      cfg.Auth.LegacyLogin,
+		OIDC:             oidcProvider,
+		OIDCPostLoginURL: cfg.OIDC.PostLoginURL,
+		LoginRateLimit: api.RateLimit{
+			PerMinute: cfg.RateLimit.LoginPerMinute,
+			Burst:     cfg.RateLimit.LoginBurst,
+		},
+		MessageRateLimit: api.RateLimit{
+			PerMinute: cfg.RateLimit.MessagesPerMinute,
+			Burst:     cfg.RateLimit.MessagesBurst,
+		},
+		UploadRateLimit: api.RateLimit{
+			PerMinute: cfg.RateLimit.UploadsPerMinute,
+			Burst:     cfg.RateLimit.UploadsBurst,
+		},
+		EventStreamTimeout: eventStreamTimeout(cfg.Web.WriteTimeout),
+		MaxPinnedMessages:  cfg.Chat.MaxPinnedMessages,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -121,6 +196,12 @@ func run() error {
 	}
 	router := apirouter.Handler()
 
+	metrics.NewGaugeFunc(
+		"wasatext_realtime_connections",
+		"Number of active real-time client connections.",
+		func() float64 { return float64(apirouter.RealtimeConnections()) },
+	)
+
 	router, err = registerWebUI(router)
 	if err != nil {
 		logger.WithError(err).Error("error registering web UI handler")
@@ -138,6 +219,7 @@ func run() error {
 		ReadHeaderTimeout: cfg.Web.ReadTimeout,
 		WriteTimeout:      cfg.Web.WriteTimeout,
 	}
+	apiserver.RegisterOnShutdown(apirouter.CloseStreams)
 
 	// Start the service listening for requests in a separate goroutine
 	go func() {
@@ -146,6 +228,23 @@ func run() error {
 		logger.Infof("stopping API server")
 	}()
 
+	// Create the debug server. No write timeout here, as CPU profiles and traces take a while to be collected
+	debugserver := http.Server{
+		Addr:              cfg.Web.DebugHost,
+		Handler:           debugHandler(),
+		ReadTimeout:       cfg.Web.ReadTimeout,
+		ReadHeaderTimeout: cfg.Web.ReadTimeout,
+	}
+
+	// The debug server is not essential: if it fails, the API keeps being served
+	go func() {
+		logger.Infof("debug server listening on %s", debugserver.Addr)
+		if err := debugserver.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.WithError(err).Error("debug server error")
+		}
+		logger.Infof("stopping debug server")
+	}()
+
 	// Waiting for shutdown signal or POSIX signals
 	select {
 	case err := <-serverErrors:
@@ -155,23 +254,29 @@ func run() error {
 	case sig := <-shutdown:
 		logger.Infof("signal %v received, start shutdown", sig)
 
-		// Asking API server to shut down and load shed.
-		err := apirouter.Close()
-		if err != nil {
-			logger.WithError(err).Warning("graceful shutdown of apirouter error")
-		}
+		// The readiness probe fails from now on, while requests are still served until load balancers notice
+		apirouter.Drain()
+		time.Sleep(cfg.Web.DrainDelay)
 
 		// Give outstanding requests a deadline for completion.
 		ctx, cancel := context.WithTimeout(context.Background(), cfg.Web.ShutdownTimeout)
 		defer cancel()
 
 		// Asking listener to shut down and load shed.
-		err = apiserver.Shutdown(ctx)
+		err := apiserver.Shutdown(ctx)
 		if err != nil {
 			logger.WithError(err).Warning("error during graceful shutdown of HTTP server")
 			err = apiserver.Close()
 		}
 
+		// Stopping the background tasks, now that no request is running
+		if closeErr := apirouter.Close(); closeErr != nil {
+			logger.WithError(closeErr).Warning("graceful shutdown of apirouter error")
+		}
+
+		// The debug server has nothing worth waiting for
+		_ = debugserver.Close()
+
 		// Log the status of this shutdown.
 		switch {
 		case sig == syscall.SIGSTOP:
@@ -183,3 +288,15 @@ func run() error {
 
 	return nil
 }
+
+// eventStreamTimeout returns the maximum duration of real-time streams: they must end before the write timeout, which
+// would otherwise cut them without a proper end of the response
+func eventStreamTimeout(writeTimeout time.Duration) time.Duration {
+	if writeTimeout <= 0 {
+		return 0
+	}
+	if writeTimeout <= 2*time.Second {
+		return writeTimeout / 2
+	}
+	return writeTimeout - time.Second
+}
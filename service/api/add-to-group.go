@@ -5,14 +5,21 @@ import (
 	"net/http"
 	"strconv"
 	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-// Helper function to return JSON errors
+// Helper function to return JSON errors. The request ID (set by wrap in the X-Request-ID header) is added to the body,
+// so that users can report it and we can find the related log entries
 func returnErrorResponse(w http.ResponseWriter, statusCode int, message string) {
+	body := map[string]string{"error": message}
+	if reqId := w.Header().Get(requestIdHeader); reqId != "" {
+		body["requestId"] = reqId
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+	_ = json.NewEncoder(w).Encode(body)
 }
 
 // Helper function for token extraction
@@ -29,10 +36,8 @@ func AuthToken(r *http.Request) (string, bool) {
 }
 
 func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	// Checking the token
-	token, valid := AuthToken(r)
-	if !valid {
-		returnErrorResponse(w, http.StatusUnauthorized, "Invalid authorization format")
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
 		return
 	}
 
@@ -43,13 +48,6 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	// Validate user authentication
-	userId, err := rt.db.GetUserIdByKey(token)
-	if err != nil {
-		returnErrorResponse(w, http.StatusUnauthorized, "Auth error")
-		return
-	}
-
 	// Check if user is a member of the chat
 	isMember, err := rt.db.ChatMember(userId, chatId)
 	if err != nil || !isMember {
@@ -90,14 +88,35 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		return
 	}
 
-	// Add users to group
-	for _, userId := range reqBody.Members {
-		err := rt.db.AddChatMember(userId, chatId)
+	// Users who blocked the caller can't be added: the error is the same as for missing users
+	for _, memberId := range reqBody.Members {
+		blocked, err := rt.db.IsBlocked(memberId, userId)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to check blocked users")
+			returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if blocked {
+			returnErrorResponse(w, http.StatusNotFound, "User not found")
+			return
+		}
+	}
+
+	// Add users to group. The users added before a failure are in the group, and are listed in the system message
+	var added []int
+	defer func() {
+		if len(added) > 0 {
+			rt.addSystemMessage(ctx, chatId, userId, database.SystemMemberAdded, "", added...)
+		}
+	}()
+	for _, memberId := range reqBody.Members {
+		err := rt.db.AddChatMember(memberId, chatId)
 		if err != nil {
 			ctx.Logger.WithError(err).Error("Failed to add user to group")
 			returnErrorResponse(w, http.StatusNotFound, "User not found")
 			return
 		}
+		added = append(added, memberId)
 	}
 
 	w.WriteHeader(http.StatusNoContent)
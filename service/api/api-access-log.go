@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"time"
+	"wasatext/service/api/reqcontext"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logAccess writes the access log entry for a request, once the handler returned. Server errors are logged at warning
+// level, everything else at info level.
+func logAccess(ctx reqcontext.RequestContext, r *http.Request, route string, rec *statusRecorder, duration time.Duration) {
+	entry := ctx.Logger.WithFields(logrus.Fields{
+		"method":   r.Method,
+		"route":    route,
+		"status":   rec.Status(),
+		"bytes":    rec.bytes,
+		"duration": duration.Seconds(),
+		"user-id":  ctx.UserId,
+	})
+
+	if rec.Status() >= http.StatusInternalServerError {
+		entry.Warning("request completed")
+	} else {
+		entry.Info("request completed")
+	}
+}
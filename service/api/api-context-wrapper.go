@@ -1,7 +1,11 @@
 package api
 
 import (
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"wasatext/service/api/reqcontext"
 
 	"github.com/gofrs/uuid"
@@ -9,30 +13,95 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// requestIdHeader is the header where the request ID is sent to the client (and received from a trusted proxy)
+const requestIdHeader = "X-Request-ID"
+
 // httpRouterHandler is the signature for functions that accepts a reqcontext.RequestContext in addition to those
 // required by the httprouter package.
 type httpRouterHandler func(http.ResponseWriter, *http.Request, httprouter.Params, reqcontext.RequestContext)
 
-// wrap parses the request and adds a reqcontext.RequestContext instance related to the request.
-func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+// wrap parses the request and adds a reqcontext.RequestContext instance related to the request. The route is the
+// template used to register the handler, and it is used as label in metrics and in the access log.
+func (rt *_router) wrap(route string, fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			httpRequests.Inc(route, r.Method, strconv.Itoa(rec.Status()))
+			httpRequestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+		}()
+
 		reqUUID, err := uuid.NewV4()
 		if err != nil {
 			rt.baseLogger.WithError(err).Error("can't generate a request UUID")
-			w.WriteHeader(http.StatusInternalServerError)
+			rec.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		var ctx = reqcontext.RequestContext{
-			ReqUUID: reqUUID,
+			ReqUUID:   reqUUID,
+			RequestID: reqUUID.String(),
+		}
+
+		// A reverse proxy may have already assigned an ID to the request
+		if rt.behindProxy {
+			if reqId := r.Header.Get(requestIdHeader); validRequestId(reqId) {
+				ctx.RequestID = reqId
+			}
+		}
+		rec.Header().Set(requestIdHeader, ctx.RequestID)
+
+		// Resolve the user, if any. Handlers still check the authorization on their own
+		if token, valid := AuthToken(r); valid {
+			if userId, err := rt.db.GetUserIdByKey(token); err == nil {
+				ctx.UserId = userId
+				rt.seen(userId)
+			}
 		}
 
 		// Create a request-specific logger
 		ctx.Logger = rt.baseLogger.WithFields(logrus.Fields{
-			"reqid":     ctx.ReqUUID.String(),
-			"remote-ip": r.RemoteAddr,
+			"reqid":     ctx.RequestID,
+			"remote-ip": rt.clientIP(r),
 		})
 
+		defer func() {
+			logAccess(ctx, r, route, rec, time.Since(start))
+		}()
+
 		// Call the next handler in chain (usually, the handler function for the path)
-		fn(w, r, ps, ctx)
+		fn(rec, r, ps, ctx)
+	}
+}
+
+// validRequestId checks that a request ID received from the proxy is safe to be logged and sent back
+func validRequestId(reqId string) bool {
+	if len(reqId) == 0 || len(reqId) > 128 {
+		return false
+	}
+	for _, c := range reqId {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// clientIP returns the IP address of the client. When the server is behind a reverse proxy, the proxy appends the
+// address of the client to the X-Forwarded-For header: only the last entry is used, as the previous ones are sent by the
+// client itself and can be forged.
+func (rt *_router) clientIP(r *http.Request) string {
+	if rt.behindProxy {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			entries := strings.Split(fwd[len(fwd)-1], ",")
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
+	return host
 }
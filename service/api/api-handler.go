@@ -7,42 +7,102 @@ import (
 // Handler returns an instance of httprouter.Router that handle APIs registered here
 func (rt *_router) Handler() http.Handler {
 	// Register routes
-	rt.router.POST("/session", rt.wrap(rt.doLogin))
+	rt.handle(http.MethodPost, "/session", rt.rateLimit(rateLimitLogin, rt.doLogin))
+	rt.handle(http.MethodPost, "/session/totp", rt.rateLimit(rateLimitLogin, rt.doLoginTotp))
+	rt.handle(http.MethodGet, "/session/oidc", rt.rateLimit(rateLimitLogin, rt.doLoginOidc))
+	rt.handle(http.MethodGet, "/session/oidc/callback", rt.rateLimit(rateLimitLogin, rt.oidcCallback))
 
-	rt.router.PUT("/users/:id/username", rt.wrap(rt.setMyUsername))
-	rt.router.GET("/users/:id/username", rt.wrap(rt.getUsername))
+	rt.handle(http.MethodGet, "/events", rt.getEvents)
 
-	rt.router.PUT("/users/:id/photo", rt.wrap(rt.setMyPhoto))
-	rt.router.GET("/users/:id/photo", rt.wrap(rt.getPhoto))
+	rt.handle(http.MethodGet, "/users", rt.searchUsers)
+	rt.handle(http.MethodGet, "/users/:id", rt.getUser)
+	rt.handle(http.MethodDelete, "/users/:id", rt.deleteMyAccount)
+	rt.handle(http.MethodGet, "/users/:id/export", rt.exportMyData)
 
-	rt.router.GET("/chats", rt.wrap(rt.getMyConversations))
+	rt.handle(http.MethodGet, "/users/:id/profile", rt.getUserProfile)
+	rt.handle(http.MethodPatch, "/users/:id/profile", rt.updateMyProfile)
+	rt.handle(http.MethodPut, "/users/:id/privacy", rt.setMyPrivacy)
+	rt.handle(http.MethodPut, "/users/:id/pinned-chats", rt.setPinnedChats)
+	rt.handle(http.MethodGet, "/users/:id/blocked", rt.getBlockedUsers)
+	rt.handle(http.MethodPut, "/users/:id/blocked/:blockedId", rt.blockUser)
+	rt.handle(http.MethodDelete, "/users/:id/blocked/:blockedId", rt.unblockUser)
 
-	rt.router.GET("/chats/:chatId", rt.wrap(rt.getConversation))
-	rt.router.POST("/chats/:chatId", rt.wrap(rt.sendMessage))
+	rt.handle(http.MethodGet, "/contacts", rt.getContacts)
+	rt.handle(http.MethodPut, "/contacts/:userId", rt.setContact)
+	rt.handle(http.MethodDelete, "/contacts/:userId", rt.removeContact)
 
-	rt.router.POST("/chats/:chatId/messages/:messageId", rt.wrap(rt.forwardMessage))
-	rt.router.GET("/chats/:chatId/messages/:messageId", rt.wrap(rt.getMessage))
-	rt.router.DELETE("/chats/:chatId/messages/:messageId", rt.wrap(rt.deleteMessage))
+	rt.handle(http.MethodPut, "/users/:id/username", rt.setMyUsername)
+	rt.handle(http.MethodGet, "/users/:id/username", rt.getUsername)
 
-	rt.router.GET("/chats/:chatId/messages/:messageId/photo", rt.wrap(rt.getMessagePhoto))
+	rt.handle(http.MethodPut, "/users/:id/password", rt.rateLimit(rateLimitLogin, rt.setMyPassword))
 
-	rt.router.POST("/chats/:chatId/messages/:messageId/comments", rt.wrap(rt.commentMessage))
-	rt.router.DELETE("/chats/:chatId/messages/:messageId/comments", rt.wrap(rt.uncommentMessage))
+	rt.handle(http.MethodPost, "/users/:id/totp", rt.enrollTotp)
+	rt.handle(http.MethodPost, "/users/:id/totp/confirm", rt.rateLimit(rateLimitLogin, rt.confirmTotp))
+	rt.handle(http.MethodDelete, "/users/:id/totp", rt.rateLimit(rateLimitLogin, rt.disableTotp))
 
-	rt.router.PUT("/chats/:chatId/chatName", rt.wrap(rt.setGroupName))
-	rt.router.GET("/chats/:chatId/chatName", rt.wrap(rt.getGroupName))
+	rt.handle(http.MethodPut, "/users/:id/photo", rt.rateLimit(rateLimitUploads, rt.setMyPhoto))
+	rt.handle(http.MethodGet, "/users/:id/photo", rt.getPhoto)
 
-	rt.router.PUT("/chats/:chatId/photo", rt.wrap(rt.setGroupPhoto))
-	rt.router.GET("/chats/:chatId/photo", rt.wrap(rt.getGroupPhoto))
+	rt.handle(http.MethodGet, "/chats", rt.getMyConversations)
+	rt.handle(http.MethodGet, "/starred", rt.getStarredMessages)
 
-	rt.router.PUT("/chats/:chatId/members", rt.wrap(rt.addToGroup))
-	rt.router.DELETE("/chats/:chatId/members", rt.wrap(rt.leaveGroup))
+	rt.handle(http.MethodGet, "/chats/:chatId", rt.getConversation)
+	rt.handle(http.MethodPost, "/chats/:chatId", rt.rateLimit(rateLimitMessages, rt.sendMessage))
+	rt.handle(http.MethodPost, "/chats/:chatId/typing", rt.setTyping)
+	rt.handle(http.MethodGet, "/chats/:chatId/settings", rt.getChatSettings)
+	rt.handle(http.MethodPatch, "/chats/:chatId/settings", rt.updateChatSettings)
+	rt.handle(http.MethodPut, "/chats/:chatId/timer", rt.setMessageTimer)
+	rt.handle(http.MethodPost, "/chats/:chatId/polls", rt.rateLimit(rateLimitMessages, rt.createPoll))
+	rt.handle(http.MethodGet, "/chats/:chatId/draft", rt.getDraft)
+	rt.handle(http.MethodPut, "/chats/:chatId/draft", rt.setDraft)
+	rt.handle(http.MethodDelete, "/chats/:chatId/draft", rt.deleteDraft)
+
+	rt.handle(http.MethodPost, "/chats/:chatId/messages/:messageId", rt.rateLimit(rateLimitMessages, rt.forwardMessage))
+	rt.handle(http.MethodGet, "/chats/:chatId/messages/:messageId", rt.getMessage)
+	rt.handle(http.MethodDelete, "/chats/:chatId/messages/:messageId", rt.deleteMessage)
+
+	rt.handle(http.MethodGet, "/chats/:chatId/scheduled", rt.getScheduledMessages)
+	rt.handle(http.MethodPatch, "/chats/:chatId/scheduled/:scheduledId", rt.updateScheduledMessage)
+	rt.handle(http.MethodDelete, "/chats/:chatId/scheduled/:scheduledId", rt.cancelScheduledMessage)
+
+	rt.handle(http.MethodGet, "/chats/:chatId/pins", rt.getPinnedMessages)
+	rt.handle(http.MethodPut, "/chats/:chatId/pins/:messageId", rt.pinMessage)
+	rt.handle(http.MethodDelete, "/chats/:chatId/pins/:messageId", rt.unpinMessage)
+
+	rt.handle(http.MethodPut, "/chats/:chatId/messages/:messageId/star", rt.starMessage)
+	rt.handle(http.MethodDelete, "/chats/:chatId/messages/:messageId/star", rt.unstarMessage)
+
+	rt.handle(http.MethodGet, "/chats/:chatId/messages/:messageId/photo", rt.getMessagePhoto)
+
+	rt.handle(http.MethodPut, "/chats/:chatId/messages/:messageId/votes", rt.votePoll)
+	rt.handle(http.MethodDelete, "/chats/:chatId/messages/:messageId/votes", rt.retractPollVote)
+	rt.handle(http.MethodPost, "/chats/:chatId/messages/:messageId/close", rt.closePoll)
+
+	rt.handle(http.MethodPost, "/chats/:chatId/messages/:messageId/comments", rt.rateLimit(rateLimitMessages, rt.commentMessage))
+	rt.handle(http.MethodDelete, "/chats/:chatId/messages/:messageId/comments", rt.uncommentMessage)
+
+	rt.handle(http.MethodPut, "/chats/:chatId/chatName", rt.setGroupName)
+	rt.handle(http.MethodGet, "/chats/:chatId/chatName", rt.getGroupName)
+
+	rt.handle(http.MethodPut, "/chats/:chatId/photo", rt.rateLimit(rateLimitUploads, rt.setGroupPhoto))
+	rt.handle(http.MethodGet, "/chats/:chatId/photo", rt.getGroupPhoto)
+
+	rt.handle(http.MethodPut, "/chats/:chatId/members", rt.addToGroup)
+	rt.handle(http.MethodDelete, "/chats/:chatId/members", rt.leaveGroup)
+	rt.handle(http.MethodPut, "/chats/:chatId/members/:userId/role", rt.setMemberRole)
 
 	// Added
-	rt.router.PUT("/newchat", rt.wrap(rt.newChat))
+	rt.handle(http.MethodPut, "/newchat", rt.newChat)
 
 	// Special routes
 	rt.router.GET("/liveness", rt.liveness)
+	rt.router.GET("/readiness", rt.readiness)
 
 	return rt.router
 }
+
+// handle registers fn for the given method and route. The route template is passed to wrap, so that metrics and logs
+// refer to "/chats/:chatId" instead of each single chat.
+func (rt *_router) handle(method string, route string, fn httpRouterHandler) {
+	rt.router.Handle(method, route, rt.wrap(route, fn))
+}
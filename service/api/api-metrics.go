@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"wasatext/service/metrics"
+)
+
+var httpRequests = metrics.NewCounterVec(
+	"wasatext_http_requests_total",
+	"Number of HTTP requests handled, partitioned by route, method and status code.",
+	"route", "method", "code",
+)
+
+var httpRequestDuration = metrics.NewHistogramVec(
+	"wasatext_http_request_duration_seconds",
+	"Latency of HTTP requests, partitioned by route and method.",
+	nil,
+	"route", "method",
+)
+
+// statusRecorder is a http.ResponseWriter that records the status code and the number of bytes sent to the client
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(statusCode int) {
+	if s.status == 0 {
+		s.status = statusCode
+	}
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += n
+	return n, err
+}
+
+// Flush allows streaming responses through the recorder
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Status returns the recorded status code. Handlers which never write anything reply with HTTP 200
+func (s *statusRecorder) Status() int {
+	if s.status == 0 {
+		return http.StatusOK
+	}
+	return s.status
+}
@@ -39,7 +39,12 @@ package api
 import (
 	"errors"
 	"net/http"
+	"sync"
+	"time"
 	"wasatext/service/database"
+	"wasatext/service/oidc"
+	"wasatext/service/ratelimit"
+	"wasatext/service/realtime"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
@@ -52,6 +57,34 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// BehindProxy should be true only when the server is behind a reverse proxy: the client IP address is then taken
+	// from the X-Forwarded-For header
+	BehindProxy bool
+
+	// LegacyLogin allows logging in with the username only (no passphrase), for accounts without a passphrase. It
+	// should be enabled only in demo environments
+	LegacyLogin bool
+
+	// OIDC is the OpenID Connect provider used for the company login. Nil disables it
+	OIDC *oidc.Provider
+
+	// OIDCPostLoginURL is where the browser is redirected after the OpenID Connect login, with the session in the URL
+	// fragment. If empty, the session is returned as JSON
+	OIDCPostLoginURL string
+
+	// LoginRateLimit, MessageRateLimit and UploadRateLimit are the rate limits for login, message sending and media
+	// uploads. Zero values disable the limit
+	LoginRateLimit   RateLimit
+	MessageRateLimit RateLimit
+	UploadRateLimit  RateLimit
+
+	// EventStreamTimeout is the maximum duration of a real-time stream, after which the client reconnects. It must be
+	// shorter than the HTTP server write timeout. Zero means no limit
+	EventStreamTimeout time.Duration
+
+	// MaxPinnedMessages is the maximum number of pinned messages in each chat. Zero disables pinning
+	MaxPinnedMessages int
 }
 
 // Router is the package API interface representing an API handler builder
@@ -59,8 +92,18 @@ type Router interface {
 	// Handler returns an HTTP handler for APIs provided in this package
 	Handler() http.Handler
 
+	// Drain makes the readiness probe report the server as unavailable, so that no new traffic is routed here.
+	// Requests are still served
+	Drain()
+
+	// CloseStreams ends the real-time event streams, which would otherwise keep the HTTP server from shutting down
+	CloseStreams()
+
 	// Close terminates any resource used in the package
 	Close() error
+
+	// RealtimeConnections returns the number of clients currently attached to the real-time event stream
+	RealtimeConnections() int
 }
 
 // New returns a new Router instance
@@ -79,11 +122,47 @@ func New(cfg Config) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-	}, nil
+	rt := &_router{
+		router:      router,
+		baseLogger:  cfg.Logger,
+		db:          cfg.Database,
+		behindProxy: cfg.BehindProxy,
+		legacyLogin: cfg.LegacyLogin,
+		limiters:    newRateLimiters(cfg),
+		challenges:  newLoginChallenges(),
+
+		oidc:             cfg.OIDC,
+		oidcPostLoginURL: cfg.OIDCPostLoginURL,
+		oidcRequests:     newOidcRequests(),
+
+		hub:                realtime.New(eventBacklogSize, eventBacklogAge),
+		eventStreamTimeout: cfg.EventStreamTimeout,
+		presence:           newPresence(),
+		typing:             newTypingStates(),
+		maxPinnedMessages:  cfg.MaxPinnedMessages,
+		streamsDone:        make(chan struct{}),
+		done:               make(chan struct{}),
+	}
+	rt.hub.OnConnect(rt.seen)
+	rt.hub.OnDisconnect(rt.seen)
+
+	// Start background tasks. They're stopped in Close()
+	rt.background.Add(1)
+	go rt.rateLimitJanitor()
+	rt.background.Add(1)
+	go rt.presenceJanitor()
+	rt.background.Add(1)
+	go rt.typingJanitor()
+	rt.background.Add(1)
+	go rt.scheduledDispatcher()
+	rt.background.Add(1)
+	go rt.messageSweeper()
+
+	return rt, nil
+}
+
+func (rt *_router) RealtimeConnections() int {
+	return rt.hub.Connections()
 }
 
 type _router struct {
@@ -94,4 +173,46 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	// behindProxy enables the X-Forwarded-For header parsing in clientIP
+	behindProxy bool
+
+	// legacyLogin allows username-only logins
+	legacyLogin bool
+
+	// limiters are the rate limiters for each route group
+	limiters map[string]*ratelimit.Limiter
+
+	// challenges are the pending two-factor login challenges
+	challenges *loginChallenges
+
+	// oidc is the OpenID Connect provider (nil if not configured), with its pending requests
+	oidc             *oidc.Provider
+	oidcPostLoginURL string
+	oidcRequests     *oidcRequests
+
+	// hub delivers real-time events to the clients, through streams lasting at most eventStreamTimeout
+	hub                *realtime.Hub
+	eventStreamTimeout time.Duration
+
+	// presence tracks the online users
+	presence *presence
+
+	// typing are the users currently typing in each chat
+	typing *typingStates
+
+	// maxPinnedMessages is the maximum number of pinned messages in each chat
+	maxPinnedMessages int
+
+	// shuttingDown is set to 1 (atomically) by Drain
+	shuttingDown int32
+
+	// streamsDone is closed by CloseStreams to end the real-time event streams
+	streamsDone   chan struct{}
+	streamsClosed int32
+
+	// done is closed by Close to stop background goroutines; background waits for them
+	done       chan struct{}
+	closed     int32
+	background sync.WaitGroup
 }
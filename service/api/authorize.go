@@ -0,0 +1,116 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// authorizeSelf checks that the bearer token belongs to the user in the `id` path parameter (the user is resolved by
+// wrap into ctx.UserId), for operations that users can do only on their own account. If not, an error is sent to the
+// client and false is returned.
+func (rt *_router) authorizeSelf(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (int, bool) {
+	if _, valid := AuthToken(r); !valid {
+		returnErrorResponse(w, http.StatusUnauthorized, "Invalid authorization format")
+		return 0, false
+	}
+
+	userId, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, false
+	}
+
+	if ctx.UserId == 0 || ctx.UserId != userId {
+		returnErrorResponse(w, http.StatusUnauthorized, "Invalid session. Please log in again.")
+		return 0, false
+	}
+
+	return userId, true
+}
+
+// authorizeUser checks that the bearer token belongs to a user (resolved by wrap into ctx.UserId), for operations
+// available to any logged in user. If not, an error is sent to the client and false is returned.
+func (rt *_router) authorizeUser(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) (int, bool) {
+	if _, valid := AuthToken(r); !valid {
+		returnErrorResponse(w, http.StatusUnauthorized, "Invalid authorization format")
+		return 0, false
+	}
+	if ctx.UserId == 0 {
+		returnErrorResponse(w, http.StatusUnauthorized, "Invalid session. Please log in again.")
+		return 0, false
+	}
+	return ctx.UserId, true
+}
+
+// pagination parses the `limit` and `offset` query parameters. limit defaults to defaultLimit and can't exceed
+// maxLimit. If they are not valid, an error is sent to the client and false is returned.
+func pagination(w http.ResponseWriter, r *http.Request, defaultLimit int, maxLimit int) (int, int, bool) {
+	query := r.URL.Query()
+
+	limit := defaultLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxLimit {
+			returnErrorResponse(w, http.StatusBadRequest, "Invalid limit")
+			return 0, 0, false
+		}
+	}
+
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		var err error
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			returnErrorResponse(w, http.StatusBadRequest, "Invalid offset")
+			return 0, 0, false
+		}
+	}
+
+	return limit, offset, true
+}
+
+// visibleMessage authorizes the user and returns the message identified by the `chatId` and `messageId` path
+// parameters, if the user is a member of the chat
+func (rt *_router) visibleMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (int, database.ChatMessage, bool) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return 0, database.ChatMessage{}, false
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return 0, database.ChatMessage{}, false
+	}
+	messageId, err := strconv.Atoi(ps.ByName("messageId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid message id")
+		return 0, database.ChatMessage{}, false
+	}
+
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return 0, database.ChatMessage{}, false
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return 0, database.ChatMessage{}, false
+	}
+
+	message, err := rt.db.GetChatMessage(chatId, messageId)
+	if errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "Message not found")
+		return 0, database.ChatMessage{}, false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return 0, database.ChatMessage{}, false
+	}
+	return userId, message, true
+}
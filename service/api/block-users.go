@@ -0,0 +1,141 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"wasatext/service/api/reqcontext"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Blocking is never disclosed to the blocked user: operations prevented by a block fail with the same errors returned
+// for users who don't exist or can't be reached.
+
+// blockUser adds the user in the `blockedId` path parameter to the block list of the user
+func (rt *_router) blockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, blockedId, ok := rt.blockListParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	if blockedId == userId {
+		returnErrorResponse(w, http.StatusBadRequest, "You can't block yourself")
+		return
+	}
+	if _, err := rt.db.GetUsername(blockedId); errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the user")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	if err := rt.db.BlockUser(userId, blockedId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to block the user")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to block the user")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unblockUser removes the user in the `blockedId` path parameter from the block list of the user
+func (rt *_router) unblockUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, blockedId, ok := rt.blockListParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	if err := rt.db.UnblockUser(userId, blockedId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to unblock the user")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to unblock the user")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getBlockedUsers returns the block list of the user
+func (rt *_router) getBlockedUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	blocked, err := rt.db.GetBlockedUsers(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the blocked users")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the blocked users")
+		return
+	}
+
+	type blockedUser struct {
+		userResponse
+		BlockedAt time.Time `json:"blockedAt"`
+	}
+	response := []blockedUser{}
+	for _, u := range blocked {
+		response = append(response, blockedUser{userResponse: newUserResponse(u.UserSummary), BlockedAt: u.BlockedAt})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"blocked": response})
+}
+
+// blockListParams authorizes the user and parses the `blockedId` path parameter
+func (rt *_router) blockListParams(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (int, int, bool) {
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return 0, 0, false
+	}
+	blockedId, err := strconv.Atoi(ps.ByName("blockedId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, 0, false
+	}
+	return userId, blockedId, true
+}
+
+// canMessagePrivately reports whether the user can send messages in the chat: always in groups, and in private chats
+// only if neither user blocked the other one
+func (rt *_router) canMessagePrivately(userId int, chatId int) (bool, error) {
+	isGroup, err := rt.db.GroupChat(chatId)
+	if err != nil || isGroup {
+		return isGroup, err
+	}
+
+	members, err := rt.db.GetChatMembers(chatId)
+	if err != nil {
+		return false, err
+	}
+	for _, member := range members {
+		if member == userId {
+			continue
+		}
+		if blocked, err := rt.db.BlockedBetween(userId, member); err != nil || blocked {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// blockedMembers reports whether a chat can't be created with these members: in private chats (two members) when
+// either of them blocked the other one, in groups when any member blocked the creator
+func (rt *_router) blockedMembers(creatorId int, members []int) (bool, error) {
+	if len(members) == 2 {
+		return rt.db.BlockedBetween(members[0], members[1])
+	}
+	for _, member := range members {
+		if member == creatorId {
+			continue
+		}
+		if blocked, err := rt.db.IsBlocked(member, creatorId); err != nil || blocked {
+			return blocked, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+	"wasatext/service/globaltime"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// chatSettingsResponse are the settings of a chat for the user
+type chatSettingsResponse struct {
+	Muted        bool       `json:"muted"`
+	MutedUntil   *time.Time `json:"mutedUntil,omitempty"`
+	Pinned       bool       `json:"pinned"`
+	Archived     bool       `json:"archived"`
+	MarkedUnread bool       `json:"markedUnread"`
+}
+
+func newChatSettingsResponse(s database.ChatSettings) chatSettingsResponse {
+	response := chatSettingsResponse{
+		Muted:        s.Muted(globaltime.Now()),
+		Pinned:       s.Pinned,
+		Archived:     s.Archived,
+		MarkedUnread: s.MarkedUnread,
+	}
+	if response.Muted {
+		mutedUntil := s.MutedUntil
+		response.MutedUntil = &mutedUntil
+	}
+	return response
+}
+
+// getChatSettings returns the settings of a chat for the user
+func (rt *_router) getChatSettings(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+
+	rt.writeChatSettings(w, ctx, userId, chatId)
+}
+
+// updateChatSettings changes the settings of a chat for the user: mute (until a time, or "forever"; an empty string
+// unmutes), pin, archive and the manual unread marker. Only the fields in the request are changed
+func (rt *_router) updateChatSettings(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+
+	var reqBody struct {
+		MutedUntil   *string `json:"mutedUntil"`
+		Pinned       *bool   `json:"pinned"`
+		Archived     *bool   `json:"archived"`
+		MarkedUnread *bool   `json:"markedUnread"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+
+	var mutedUntil *time.Time
+	if reqBody.MutedUntil != nil {
+		var until time.Time
+		switch *reqBody.MutedUntil {
+		case "":
+		case "forever":
+			until = database.MutedForever
+		default:
+			until, err = time.Parse(time.RFC3339, *reqBody.MutedUntil)
+			if err != nil {
+				returnErrorResponse(w, http.StatusBadRequest, "mutedUntil must be a date-time, \"forever\" or empty")
+				return
+			}
+		}
+		mutedUntil = &until
+	}
+
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	updated, err := rt.db.UpdateChatSettings(userId, chatId, mutedUntil, reqBody.Pinned, reqBody.Archived, reqBody.MarkedUnread)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to update the chat settings")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to update the chat settings")
+		return
+	}
+	if !updated {
+		returnErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("You can pin at most %d conversations", database.MaxPinnedChats))
+		return
+	}
+
+	rt.writeChatSettings(w, ctx, userId, chatId)
+}
+
+// writeChatSettings writes the settings of a chat for the user as response
+func (rt *_router) writeChatSettings(w http.ResponseWriter, ctx reqcontext.RequestContext, userId int, chatId int) {
+	settings, err := rt.db.GetChatSettings(userId, chatId)
+	if errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the chat settings")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the chat settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(newChatSettingsResponse(settings))
+}
+
+// setPinnedChats reorders the pinned chats of the user. The request lists every pinned chat, in the new order
+func (rt *_router) setPinnedChats(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		ChatIds []int `json:"chatIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+
+	pinned, err := rt.db.GetPinnedChats(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the pinned chats")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to reorder the pinned chats")
+		return
+	}
+	isPinned := make(map[int]bool, len(pinned))
+	for _, chatId := range pinned {
+		isPinned[chatId] = true
+	}
+	for _, chatId := range reqBody.ChatIds {
+		if !isPinned[chatId] {
+			returnErrorResponse(w, http.StatusBadRequest, "chatIds must list every pinned conversation once")
+			return
+		}
+		delete(isPinned, chatId)
+	}
+	if len(isPinned) > 0 {
+		returnErrorResponse(w, http.StatusBadRequest, "chatIds must list every pinned conversation once")
+		return
+	}
+
+	if err := rt.db.ReorderPinnedChats(userId, reqBody.ChatIds); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to reorder the pinned chats")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to reorder the pinned chats")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,12 +1,41 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 	"wasatext/service/api/reqcontext"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// commentMessage sets the comment of the user on a message, replacing the previous one. System messages can't be
+// commented
 func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.visibleMessage(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+	if message.System != nil {
+		returnErrorResponse(w, http.StatusForbidden, "System messages can't be commented")
+		return
+	}
 
+	var comment string
+	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	if strings.TrimSpace(comment) == "" || utf8.RuneCountInString(comment) > maxMessageLength {
+		returnErrorResponse(w, http.StatusBadRequest, "The comment must be between 1 and 2000 characters")
+		return
+	}
+
+	if err := rt.db.AddComment(comment, userId, message.ID); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to add the comment")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to add the comment")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/globaltime"
+	"wasatext/service/totp"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// confirmTotp enables two-factor authentication once the user sends a valid code for the secret generated by
+// enrollTotp. The recovery codes are returned only here: they are stored hashed.
+func (rt *_router) confirmTotp(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	secret, enabled, lastStep, err := rt.db.GetTotp(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the TOTP status")
+		returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if enabled {
+		returnErrorResponse(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if secret == "" {
+		returnErrorResponse(w, http.StatusNotFound, "No pending two-factor enrolment")
+		return
+	}
+
+	step, valid := totp.Validate(secret, requestBody.Code, globaltime.Now(), lastStep)
+	if !valid {
+		returnErrorResponse(w, http.StatusForbidden, "Invalid code")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to generate recovery codes")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+
+	if err := rt.db.EnableTotp(userId, step, hashes); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to enable TOTP")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	response := struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
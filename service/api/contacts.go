@@ -0,0 +1,123 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+	"wasatext/service/utils"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// getContacts returns the contact list of the user, favourites first, with the private chat with each contact (if
+// any)
+func (rt *_router) getContacts(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	contacts, err := rt.db.GetContacts(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the contacts")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the contacts")
+		return
+	}
+
+	type contact struct {
+		userResponse
+		Favourite bool `json:"favourite"`
+		ChatId    int  `json:"chatId,omitempty"`
+	}
+	response := []contact{}
+	for _, c := range contacts {
+		entry := contact{userResponse: newUserResponse(c.UserSummary), Favourite: c.Favourite, ChatId: c.ChatId}
+		entry.Nickname = c.Nickname
+		response = append(response, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"contacts": response})
+}
+
+// setContact adds a user to the contact list, or updates the nickname and the favourite flag of a contact. The body
+// is optional: only the fields in it are changed, and an empty nickname clears it
+func (rt *_router) setContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, contactId, ok := rt.contactParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		Nickname  *string `json:"nickname"`
+		Favourite *bool   `json:"favourite"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil && !errors.Is(err, io.EOF) {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	if reqBody.Nickname != nil {
+		*reqBody.Nickname = strings.TrimSpace(*reqBody.Nickname)
+		if !utils.ValidProfileText(*reqBody.Nickname, database.MaxNicknameLength, false) {
+			returnErrorResponse(w, http.StatusBadRequest, "The nickname must be at most 64 characters long, on one line")
+			return
+		}
+	}
+
+	if contactId == userId {
+		returnErrorResponse(w, http.StatusBadRequest, "You can't add yourself as a contact")
+		return
+	}
+	user, err := rt.db.GetUser(contactId)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && user.Deleted {
+		returnErrorResponse(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the user")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to update the contact")
+		return
+	}
+
+	if err := rt.db.SetContact(userId, contactId, reqBody.Nickname, reqBody.Favourite); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to update the contact")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to update the contact")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeContact removes a user from the contact list
+func (rt *_router) removeContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, contactId, ok := rt.contactParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	if err := rt.db.RemoveContact(userId, contactId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to remove the contact")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to remove the contact")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// contactParams authorizes the user and parses the `userId` path parameter
+func (rt *_router) contactParams(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (int, int, bool) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return 0, 0, false
+	}
+	contactId, err := strconv.Atoi(ps.ByName("userId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, 0, false
+	}
+	return userId, contactId, true
+}
@@ -7,6 +7,34 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
+// deleteMessage deletes a message sent by the user. If the message was pinned, the chat members are notified that it
+// isn't anymore
 func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.visibleMessage(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+	if message.SenderId != userId {
+		returnErrorResponse(w, http.StatusForbidden, "You can only delete your own messages")
+		return
+	}
+	if message.System != nil {
+		returnErrorResponse(w, http.StatusForbidden, "System messages can't be deleted")
+		return
+	}
 
+	// DeleteMessage removes the pin too, but the members must know about it
+	wasPinned, err := rt.db.UnpinMessage(message.ChatId, message.ID)
+	if err == nil {
+		err = rt.db.DeleteMessage(message.ID)
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to delete the message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to delete the message")
+		return
+	}
+	if wasPinned {
+		rt.publishPin(pinEvent{ChatId: message.ChatId, MessageId: message.ID, UserId: userId, Pinned: false})
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
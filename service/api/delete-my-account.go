@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+	"wasatext/service/api/reqcontext"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// deleteMyAccount deletes the account of the user. Messages sent in chats are kept, shown as sent by a deleted user;
+// everything else (photo, credentials, sessions and memberships) is removed.
+func (rt *_router) deleteMyAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// Users can delete only their own account
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	// The new key is never returned: it only revokes every session
+	securityKey, err := generateApiKey()
+	if err != nil {
+		returnErrorResponse(w, http.StatusInternalServerError, "Error generating API key")
+		return
+	}
+
+	if err := rt.db.DeleteUser(userId, securityKey); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to delete the account")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to delete the account")
+		return
+	}
+
+	ctx.Logger.WithField("user-id", userId).Info("account deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
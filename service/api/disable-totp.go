@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"wasatext/service/api/reqcontext"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// disableTotp turns off two-factor authentication. A valid TOTP code or a recovery code is required.
+func (rt *_router) disableTotp(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	_, enabled, _, err := rt.db.GetTotp(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the TOTP status")
+		returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	// A pending (not confirmed) enrolment can be discarded without a code
+	if enabled {
+		valid, err := rt.verifySecondFactor(userId, requestBody.Code)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to verify the code")
+			returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if !valid {
+			returnErrorResponse(w, http.StatusForbidden, "Invalid code")
+			return
+		}
+	}
+
+	if err := rt.db.DisableTotp(userId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to disable TOTP")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
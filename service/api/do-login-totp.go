@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"wasatext/service/api/reqcontext"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// doLoginTotp is the second login step for users with two-factor authentication: it exchanges the challenge token
+// returned by doLogin and a TOTP (or recovery) code for the session.
+func (rt *_router) doLoginTotp(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	var requestBody struct {
+		ChallengeToken string `json:"challengeToken"`
+		Code           string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	userId, ok := rt.challenges.attempt(requestBody.ChallengeToken)
+	if !ok {
+		returnErrorResponse(w, http.StatusUnauthorized, "Login challenge expired, please log in again")
+		return
+	}
+
+	valid, err := rt.verifySecondFactor(userId, requestBody.Code)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to verify the code")
+		returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !valid {
+		ctx.Logger.WithField("user-id", userId).Info("login failed: wrong second factor")
+		returnErrorResponse(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	rt.challenges.complete(requestBody.ChallengeToken)
+
+	username, err := rt.db.GetUsername(userId)
+	if err != nil {
+		returnErrorResponse(w, http.StatusInternalServerError, "Error retrieving username")
+		return
+	}
+
+	apiKey, err := rt.db.GetUserKey(userId)
+	if err != nil {
+		returnErrorResponse(w, http.StatusInternalServerError, "Error retrieving API key")
+		return
+	}
+
+	response := struct {
+		Username string `json:"username"`
+		UserId   int    `json:"userId"`
+		APIKey   string `json:"apiKey"`
+	}{
+		Username: username,
+		UserId:   userId,
+		APIKey:   apiKey,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(response)
+}
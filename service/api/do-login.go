@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"math/big"
 	"net/http"
+	"time"
 	"wasatext/service/api/reqcontext"
 	"wasatext/service/utils"
 
@@ -30,25 +31,29 @@ func generateApiKey() (string, error) {
 
 func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	var requestBody struct {
-		Name string `json:"name"`
+		Name     string `json:"name"`
+		Password string `json:"password"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid request body"})
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	username := requestBody.Name
 	if !utils.ValidUsername(username) {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Username must be alphanumeric and between 3 and 16 characters"})
+		returnErrorResponse(w, http.StatusBadRequest, "Username must be alphanumeric and between 3 and 16 characters")
+		return
+	}
+
+	// The passphrase is optional only in legacy (username-only) mode
+	if requestBody.Password == "" && !rt.legacyLogin {
+		returnErrorResponse(w, http.StatusBadRequest, "A passphrase is required")
 		return
 	}
 
 	exists, err := rt.db.UserExists(username)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
+		returnErrorResponse(w, http.StatusInternalServerError, "Database error")
 		return
 	}
 
@@ -58,29 +63,98 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, ps httprouter
 	if !exists {
 		apiKey, err = generateApiKey()
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Error generating API key"})
+			returnErrorResponse(w, http.StatusInternalServerError, "Error generating API key")
 			return
 		}
 
-		userId, err = rt.db.CreateUser(username, apiKey)
+		var passwordHash string
+		if requestBody.Password != "" {
+			if !utils.ValidPassphrase(requestBody.Password) {
+				returnErrorResponse(w, http.StatusBadRequest, "Passphrase must be between 8 and 72 characters")
+				return
+			}
+			passwordHash, err = hashPassphrase(requestBody.Password)
+			if err != nil {
+				ctx.Logger.WithError(err).Error("Failed to hash the passphrase")
+				returnErrorResponse(w, http.StatusInternalServerError, "Error adding new user")
+				return
+			}
+		}
+
+		userId, err = rt.db.CreateUser(username, apiKey, passwordHash)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Error adding new user"})
+			returnErrorResponse(w, http.StatusInternalServerError, "Error adding new user")
 			return
 		}
 	} else {
 		userId, err = rt.db.GetUserIdByUsername(username)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Error retrieving user ID"})
+			returnErrorResponse(w, http.StatusInternalServerError, "Error retrieving user ID")
+			return
+		}
+
+		// Accounts created by the identity provider log in only there, also in legacy mode: they have no passphrase,
+		// so the username alone would be enough
+		oidcUser, err := rt.db.HasOidcIdentity(userId)
+		if err != nil {
+			returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if oidcUser {
+			returnErrorResponse(w, http.StatusForbidden, "This account signs in with the identity provider")
+			return
+		}
+
+		// Verify the passphrase. Accounts without one can log in only in legacy mode
+		passwordHash, err := rt.db.GetPasswordHash(userId)
+		if err != nil {
+			returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if passwordHash == "" && !rt.legacyLogin {
+			returnErrorResponse(w, http.StatusForbidden, "This account has no passphrase, please contact an administrator")
+			return
+		}
+		if passwordHash != "" && !checkPassphrase(passwordHash, requestBody.Password) {
+			ctx.Logger.WithField("user-id", userId).Info("login failed: wrong passphrase")
+			rt.loginFailed(username)
+			returnErrorResponse(w, http.StatusUnauthorized, "Invalid username or passphrase")
+			return
+		}
+
+		// With two-factor authentication, the session is granted by doLoginTotp
+		_, totpEnabled, _, err := rt.db.GetTotp(userId)
+		if err != nil {
+			returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if totpEnabled {
+			challengeToken, expires, err := rt.challenges.create(userId)
+			if err != nil {
+				ctx.Logger.WithError(err).Error("Failed to create the login challenge")
+				returnErrorResponse(w, http.StatusInternalServerError, "Error creating login challenge")
+				return
+			}
+
+			response := struct {
+				TwoFactorRequired bool      `json:"twoFactorRequired"`
+				ChallengeToken    string    `json:"challengeToken"`
+				ExpiresAt         time.Time `json:"expiresAt"`
+			}{
+				TwoFactorRequired: true,
+				ChallengeToken:    challengeToken,
+				ExpiresAt:         expires,
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(response)
 			return
 		}
 
 		apiKey, err = rt.db.GetUserKey(userId)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Error retrieving API key"})
+			returnErrorResponse(w, http.StatusInternalServerError, "Error retrieving API key")
 			return
 		}
 	}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+	"wasatext/service/globaltime"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// draftResponse is the draft of the user in a chat
+type draftResponse struct {
+	Text      string    `json:"text"`
+	ReplyTo   *int      `json:"replyTo,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func newDraftResponse(d database.Draft) draftResponse {
+	response := draftResponse{Text: d.Text, UpdatedAt: d.UpdatedAt}
+	if d.ReplyTo != 0 {
+		replyTo := d.ReplyTo
+		response.ReplyTo = &replyTo
+	}
+	return response
+}
+
+// draftEvent is the payload of "draft" real-time events, sent to the sessions of the user. Draft is null when the
+// draft is deleted (or sent)
+type draftEvent struct {
+	ChatId int            `json:"chatId"`
+	Draft  *draftResponse `json:"draft"`
+}
+
+// getDraft returns the draft of the user in a chat
+func (rt *_router) getDraft(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, chatId, ok := rt.draftParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	draft, err := rt.db.GetDraft(userId, chatId)
+	if errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "Draft not found")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the draft")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the draft")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(newDraftResponse(draft))
+}
+
+// setDraft saves the draft of the user in a chat, replacing the previous one, and sends it to the other sessions of
+// the user. The text is kept as written: the formatting is checked when the message is sent
+func (rt *_router) setDraft(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, chatId, ok := rt.draftParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		Text    string `json:"text"`
+		ReplyTo int    `json:"replyTo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	if strings.TrimSpace(reqBody.Text) == "" && reqBody.ReplyTo == 0 {
+		returnErrorResponse(w, http.StatusBadRequest, "The draft is empty")
+		return
+	}
+	if utf8.RuneCountInString(reqBody.Text) > maxMessageLength {
+		returnErrorResponse(w, http.StatusBadRequest, "The draft is too long")
+		return
+	}
+	if reqBody.ReplyTo != 0 {
+		if _, err := rt.db.GetChatMessage(chatId, reqBody.ReplyTo); errors.Is(err, sql.ErrNoRows) {
+			returnErrorResponse(w, http.StatusNotFound, "Message not found")
+			return
+		} else if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to retrieve the message")
+			returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+	}
+
+	draft := database.Draft{Text: reqBody.Text, ReplyTo: reqBody.ReplyTo, UpdatedAt: globaltime.Now()}
+	if err := rt.db.SetDraft(userId, chatId, draft); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to save the draft")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to save the draft")
+		return
+	}
+	response := newDraftResponse(draft)
+	rt.hub.Publish("draft", draftEvent{ChatId: chatId, Draft: &response}, userId)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// deleteDraft deletes the draft of the user in a chat. Deleting a missing draft is not an error
+func (rt *_router) deleteDraft(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, chatId, ok := rt.draftParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	if err := rt.clearDraft(userId, chatId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to delete the draft")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to delete the draft")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearDraft deletes the draft of the user in a chat, if any, and tells the sessions of the user
+func (rt *_router) clearDraft(userId int, chatId int) error {
+	deleted, err := rt.db.DeleteDraft(userId, chatId)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		rt.hub.Publish("draft", draftEvent{ChatId: chatId}, userId)
+	}
+	return nil
+}
+
+// draftParams returns the user and the chat of a draft request, checking that the user is a member of the chat. If
+// not, the error response is sent
+func (rt *_router) draftParams(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (int, int, bool) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return 0, 0, false
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return 0, 0, false
+	}
+
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return 0, 0, false
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return 0, 0, false
+	}
+	return userId, chatId, true
+}
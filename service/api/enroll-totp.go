@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/totp"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// enrollTotp generates a new TOTP secret for the user. Two-factor authentication is enabled only after the user
+// confirms it with a code from the authenticator app (see confirmTotp).
+func (rt *_router) enrollTotp(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	// Two-factor authentication protects the passphrase login, so a passphrase is needed
+	passwordHash, err := rt.db.GetPasswordHash(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the passphrase")
+		returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if passwordHash == "" {
+		returnErrorResponse(w, http.StatusForbidden, "Set a passphrase before enabling two-factor authentication")
+		return
+	}
+
+	_, enabled, _, err := rt.db.GetTotp(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the TOTP status")
+		returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if enabled {
+		returnErrorResponse(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	username, err := rt.db.GetUsername(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the username")
+		returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to generate the TOTP secret")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to generate the secret")
+		return
+	}
+
+	if err := rt.db.SetTotpSecret(userId, secret); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to save the TOTP secret")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to save the secret")
+		return
+	}
+
+	response := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, username, secret),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(response)
+}
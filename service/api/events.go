@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/realtime"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// eventBacklogSize and eventBacklogAge limit the events kept for each user, to be replayed on reconnection
+	eventBacklogSize = 256
+	eventBacklogAge  = 2 * time.Minute
+
+	// eventKeepAlive is the interval between comments sent on idle streams, so that proxies don't close them
+	eventKeepAlive = 15 * time.Second
+
+	// eventRetry is the reconnection delay suggested to clients (in milliseconds)
+	eventRetry = 1000
+)
+
+// getEvents is the real-time channel: a Server-Sent Events stream with the events of the user. Streams end before the
+// server write timeout (see Config.EventStreamTimeout); clients reconnect sending the Last-Event-ID header (or the
+// `lastEventId` query parameter) and receive the events published in the meantime.
+func (rt *_router) getEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		returnErrorResponse(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+	var after uint64
+	if lastEventId != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastEventId, 10, 64); err != nil {
+			returnErrorResponse(w, http.StatusBadRequest, "Invalid last event ID")
+			return
+		}
+	}
+
+	sub, missed := rt.hub.Subscribe(userId, after)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+	for _, event := range missed {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	var timeout <-chan time.Time
+	if rt.eventStreamTimeout > 0 {
+		timer := time.NewTimer(rt.eventStreamTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, open := <-sub.Events():
+			if !open {
+				// Closed by the hub, as the client is too slow: it will catch up from the backlog
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-timeout:
+			return
+		case <-r.Context().Done():
+			return
+		case <-rt.streamsDone:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes an event in the Server-Sent Events format, with the data encoded as JSON
+func writeEvent(w http.ResponseWriter, event realtime.Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/globaltime"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// exportMyData returns a ZIP archive with the personal data of the user: profile.json (and photo.gif),
+// chats.json, contacts.json, messages.json (with the GIFs sent in messages/) and reactions.json.
+func (rt *_router) exportMyData(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// Users can export only their own data
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	archive, err := rt.buildExport(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to build the data export")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to export the data")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="wasatext-export-%d.zip"`, userId))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(archive)
+}
+
+// buildExport collects the data of the user into a ZIP archive
+func (rt *_router) buildExport(userId int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	addFile := func(name string, content []byte) error {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(content)
+		return err
+	}
+	addJSON := func(name string, value interface{}) error {
+		content, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		return addFile(name, content)
+	}
+
+	// Profile
+	username, err := rt.db.GetUsername(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving username: %w", err)
+	}
+	photo, err := rt.db.GetUserPhoto(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving photo: %w", err)
+	}
+	passwordHash, err := rt.db.GetPasswordHash(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving passphrase: %w", err)
+	}
+	_, totpEnabled, _, err := rt.db.GetTotp(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving two-factor status: %w", err)
+	}
+	user, err := rt.db.GetUser(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving profile: %w", err)
+	}
+	profile := map[string]interface{}{
+		"userId":           userId,
+		"username":         username,
+		"displayName":      user.DisplayName,
+		"bio":              user.Bio,
+		"status":           user.Status,
+		"lastSeenPrivacy":  user.LastSeenPrivacy,
+		"hasPhoto":         len(photo) > 0,
+		"hasPassphrase":    passwordHash != "",
+		"twoFactorEnabled": totpEnabled,
+		"exportedAt":       globaltime.Now(),
+	}
+	if err := addJSON("profile.json", profile); err != nil {
+		return nil, err
+	}
+	if len(photo) > 0 {
+		if err := addFile("photo.gif", photo); err != nil {
+			return nil, err
+		}
+	}
+
+	// Chats
+	type exportedChat struct {
+		ChatId    int    `json:"chatId"`
+		Name      string `json:"name"`
+		GroupChat bool   `json:"groupChat"`
+		Members   []int  `json:"members"`
+	}
+	chatIds, err := rt.db.GetUserChats(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving chats: %w", err)
+	}
+	nicknames, err := rt.db.GetContactNicknames(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving nicknames: %w", err)
+	}
+	chats := []exportedChat{}
+	for _, chatId := range chatIds {
+		chat := exportedChat{ChatId: chatId, Members: []int{}}
+		name, err := rt.db.GetChatName(chatId)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving chat %d name: %w", chatId, err)
+		}
+		if chat.GroupChat, err = rt.db.GroupChat(chatId); err != nil {
+			return nil, fmt.Errorf("retrieving chat %d type: %w", chatId, err)
+		}
+		members, err := rt.db.GetChatMemberProfiles(chatId)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving chat %d members: %w", chatId, err)
+		}
+		chat.Name = chatName(userId, name, chat.GroupChat, members, nicknames)
+		for _, member := range members {
+			chat.Members = append(chat.Members, member.ID)
+		}
+		chats = append(chats, chat)
+	}
+	if err := addJSON("chats.json", chats); err != nil {
+		return nil, err
+	}
+
+	// Contacts
+	type exportedContact struct {
+		UserId    int    `json:"userId"`
+		Username  string `json:"username"`
+		Nickname  string `json:"nickname,omitempty"`
+		Favourite bool   `json:"favourite"`
+	}
+	contactList, err := rt.db.GetContacts(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving contacts: %w", err)
+	}
+	contacts := []exportedContact{}
+	for _, c := range contactList {
+		contacts = append(contacts, exportedContact{UserId: c.ID, Username: c.Username, Nickname: c.Nickname, Favourite: c.Favourite})
+	}
+	if err := addJSON("contacts.json", contacts); err != nil {
+		return nil, err
+	}
+
+	// Sent messages, with their GIFs
+	type exportedMessage struct {
+		MessageId   int       `json:"messageId"`
+		ChatId      int       `json:"chatId"`
+		TextContent string    `json:"textContent,omitempty"`
+		Photo       string    `json:"photo,omitempty"`
+		Forwarded   bool      `json:"forwarded"`
+		Timestamp   time.Time `json:"timestamp"`
+	}
+	sent, err := rt.db.GetSentMessages(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving messages: %w", err)
+	}
+	messages := []exportedMessage{}
+	for _, m := range sent {
+		exported := exportedMessage{
+			MessageId:   m.ID,
+			ChatId:      m.ChatId,
+			TextContent: m.TextContent,
+			Forwarded:   m.Forwarded,
+			Timestamp:   m.Timestamp,
+		}
+		if len(m.Photo) > 0 {
+			exported.Photo = fmt.Sprintf("messages/%d.gif", m.ID)
+			if err := addFile(exported.Photo, m.Photo); err != nil {
+				return nil, err
+			}
+		}
+		messages = append(messages, exported)
+	}
+	if err := addJSON("messages.json", messages); err != nil {
+		return nil, err
+	}
+
+	// Reactions (comments on messages)
+	type exportedReaction struct {
+		MessageId int    `json:"messageId"`
+		ChatId    int    `json:"chatId"`
+		Comment   string `json:"comment"`
+	}
+	comments, err := rt.db.GetUserComments(userId)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving reactions: %w", err)
+	}
+	reactions := []exportedReaction{}
+	for _, c := range comments {
+		reactions = append(reactions, exportedReaction{MessageId: c.MessageId, ChatId: c.ChatId, Comment: c.Comment})
+	}
+	if err := addJSON("reactions.json", reactions); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -1,12 +1,165 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// messageResponse is a message as listed in conversations
+type messageResponse struct {
+	MessageId   int             `json:"messageId"`
+	SenderId    int             `json:"senderId"`
+	SenderName  string          `json:"senderName"`
+	TextContent string          `json:"textContent,omitempty"`
+	HasPhoto    bool            `json:"hasPhoto"`
+	Forwarded   bool            `json:"forwarded"`
+	Timestamp   time.Time       `json:"timestamp"`
+	ExpiresAt   *time.Time      `json:"expiresAt,omitempty"`
+	Entities    []entity        `json:"entities"`
+	Poll        *pollResponse   `json:"poll,omitempty"`
+	System      *systemResponse `json:"system,omitempty"`
+}
+
+// newMessageResponse returns the message as seen by the user (who can see their own votes in anonymous polls)
+func newMessageResponse(m database.ChatMessage, userId int) messageResponse {
+	response := messageResponse{
+		MessageId:   m.ID,
+		SenderId:    m.SenderId,
+		SenderName:  m.SenderName,
+		TextContent: m.TextContent,
+		HasPhoto:    m.HasPhoto,
+		Forwarded:   m.Forwarded,
+		Timestamp:   m.Timestamp,
+		Entities:    newEntities(m),
+	}
+	if !m.ExpiresAt.IsZero() {
+		expiresAt := m.ExpiresAt
+		response.ExpiresAt = &expiresAt
+	}
+	if m.Poll != nil {
+		poll := newPollResponse(*m.Poll, userId)
+		response.Poll = &poll
+	}
+	if m.System != nil {
+		// The text of system messages is part of the event
+		system := newSystemResponse(*m.System)
+		response.System = &system
+		response.TextContent = ""
+	}
+	return response
+}
+
+// getConversation returns the members and the messages of a chat: all of them, or a page if the `limit` or `offset`
+// query parameters are set. The returned messages are marked as seen by the user
 func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	// Pages start from the most recent messages; without paging, the whole conversation is returned
+	query := r.URL.Query()
+	paged := query.Get("limit") != "" || query.Get("offset") != ""
+	var limit, offset int
+	if paged {
+		if limit, offset, ok = pagination(w, r, 50, 200); !ok {
+			return
+		}
+	}
+
+	var response struct {
+		ChatId    int               `json:"chatId"`
+		Name      string            `json:"name"`
+		GroupChat bool              `json:"groupChat"`
+		Timer     int64             `json:"messageTimer"`
+		Members   []userResponse    `json:"members"`
+		Messages  []messageResponse `json:"messages"`
+		// NextOffset is the offset of the page of older messages
+		NextOffset *int `json:"nextOffset,omitempty"`
+	}
+	response.ChatId = chatId
+	name, err := rt.db.GetChatName(chatId)
+	if err == nil {
+		response.GroupChat, err = rt.db.GroupChat(chatId)
+	}
+	if err == nil {
+		var timer time.Duration
+		timer, err = rt.db.GetMessageTimer(chatId)
+		response.Timer = int64(timer / time.Second)
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the chat")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the conversation")
+		return
+	}
+
+	members, err := rt.db.GetChatMemberProfiles(chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the chat members")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the conversation")
+		return
+	}
+	nicknames, err := rt.db.GetContactNicknames(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the contacts")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the conversation")
+		return
+	}
+	response.Name = chatName(userId, name, response.GroupChat, members, nicknames)
+	response.Members = newMemberResponses(members, nicknames)
+
+	var messages []database.ChatMessage
+	if paged {
+		messages, err = rt.db.GetChatMessagePage(chatId, limit, offset)
+	} else {
+		messages, err = rt.db.GetChatMessageList(chatId)
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the messages")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the conversation")
+		return
+	}
+	response.Messages = []messageResponse{}
+	messageIds := []int{}
+	for _, m := range messages {
+		response.Messages = append(response.Messages, newMessageResponse(m, userId))
+		messageIds = append(messageIds, m.ID)
+	}
+	// A full page means that there may be older messages
+	if paged && len(messages) == limit {
+		next := offset + limit
+		response.NextOffset = &next
+	}
+
+	// Only the messages returned are seen: the older pages are not read yet
+	if err := rt.db.MarkChatSeen(userId, chatId, messageIds); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to mark the messages as seen")
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
 }
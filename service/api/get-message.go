@@ -1,12 +1,29 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
 	"wasatext/service/api/reqcontext"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// getMessage returns a message of a chat, with its text both as written (with the formatting markup) and as plain
+// text with its entities
 func (rt *_router) getMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.visibleMessage(w, r, ps, ctx)
+	if !ok {
+		return
+	}
 
+	var response struct {
+		messageResponse
+		RawText string `json:"rawText,omitempty"`
+	}
+	response.messageResponse = newMessageResponse(message, userId)
+	response.RawText = message.RawText
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
 }
@@ -1,12 +1,94 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 	"wasatext/service/api/reqcontext"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// conversationResponse is a chat as listed in the conversations of the user
+type conversationResponse struct {
+	ChatId      int              `json:"chatId"`
+	Name        string           `json:"name"`
+	GroupChat   bool             `json:"groupChat"`
+	HasPhoto    bool             `json:"hasPhoto"`
+	Unread      int              `json:"unread"`
+	Mentions    int              `json:"unreadMentions"`
+	Timer       int64            `json:"messageTimer"`
+	LastMessage *messageResponse `json:"lastMessage,omitempty"`
+	Draft       *draftResponse   `json:"draft,omitempty"`
+	Members     []userResponse   `json:"members"`
+	chatSettingsResponse
+}
+
+// getMyConversations returns the conversations of the user, pinned first, with their settings. Archived conversations
+// are included only with `archived=true`
 func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	archived := false
+	if value := r.URL.Query().Get("archived"); value != "" {
+		var err error
+		if archived, err = strconv.ParseBool(value); err != nil {
+			returnErrorResponse(w, http.StatusBadRequest, "Invalid archived parameter")
+			return
+		}
+	}
+
+	conversations, err := rt.db.GetConversations(userId, archived)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the conversations")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the conversations")
+		return
+	}
+
+	nicknames, err := rt.db.GetContactNicknames(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the contacts")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the conversations")
+		return
+	}
+
+	response := []conversationResponse{}
+	for _, c := range conversations {
+		members, err := rt.db.GetChatMemberProfiles(c.ID)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to retrieve the chat members")
+			returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the conversations")
+			return
+		}
+
+		conversation := conversationResponse{
+			ChatId:    c.ID,
+			Name:      chatName(userId, c.Name, c.GroupChat, members, nicknames),
+			GroupChat: c.GroupChat,
+			HasPhoto:  c.HasPhoto,
+			Unread:    c.Unread,
+			Mentions:  c.Mentions,
+			Timer:     int64(c.MessageTimer / time.Second),
+			Members:   newMemberResponses(members, nicknames),
+
+			chatSettingsResponse: newChatSettingsResponse(c.Settings),
+		}
+		if c.LastMessage != nil {
+			lastMessage := newMessageResponse(*c.LastMessage, userId)
+			conversation.LastMessage = &lastMessage
+		}
+		if c.Draft != nil {
+			draft := newDraftResponse(*c.Draft)
+			conversation.Draft = &draft
+		}
+		response = append(response, conversation)
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"userChats": response})
 }
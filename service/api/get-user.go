@@ -0,0 +1,75 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"wasatext/service/api/reqcontext"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// getUser returns the public information about a user. The online state and the last seen timestamp are included
+// only if the user allows the caller to see them.
+func (rt *_router) getUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	callerId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	userId, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := rt.db.GetUser(userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the user")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the user")
+		return
+	}
+
+	var response struct {
+		userResponse
+		Bio             string     `json:"bio,omitempty"`
+		Deleted         bool       `json:"deleted"`
+		Online          *bool      `json:"online,omitempty"`
+		LastSeen        *time.Time `json:"lastSeen,omitempty"`
+		LastSeenPrivacy string     `json:"lastSeenPrivacy,omitempty"`
+	}
+	response.userResponse = newUserResponse(user.UserSummary)
+	response.Bio = user.Bio
+	response.Deleted = user.Deleted
+
+	visible, err := rt.presenceVisible(user, callerId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check the presence visibility")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the user")
+		return
+	}
+	if visible {
+		online, lastActive := rt.online(user.ID)
+		response.Online = &online
+		switch {
+		case online:
+			response.LastSeen = &lastActive
+		case !user.LastSeen.IsZero():
+			response.LastSeen = &user.LastSeen
+		}
+	}
+	// The privacy setting is private too
+	if callerId == user.ID {
+		response.LastSeenPrivacy = user.LastSeenPrivacy
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
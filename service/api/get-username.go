@@ -14,8 +14,7 @@ func (rt *_router) getUsername(w http.ResponseWriter, r *http.Request, ps httpro
 
 	const bearerPrefix = "Bearer "
 	if len(authHeader) <= len(bearerPrefix) || authHeader[:len(bearerPrefix)] != bearerPrefix {
-		w.WriteHeader(http.StatusUnauthorized)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid authorization format"})
+		returnErrorResponse(w, http.StatusUnauthorized, "Invalid authorization format")
 		return
 	}
 
@@ -23,8 +22,7 @@ func (rt *_router) getUsername(w http.ResponseWriter, r *http.Request, ps httpro
 	token := authHeader[len(bearerPrefix):]
 
 	if token == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Empty token"})
+		returnErrorResponse(w, http.StatusUnauthorized, "Empty token")
 	}
 	// Extract the user id from the url path
 	getUid := ps.ByName("id")
@@ -32,16 +30,14 @@ func (rt *_router) getUsername(w http.ResponseWriter, r *http.Request, ps httpro
 	// Convert user id to integer
 	reqUid, err := strconv.Atoi(getUid)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid or unauthorized user ID"})
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid or unauthorized user ID")
 		return
 	}
 
 	// Retrieve the user's name from the database
 	username, err := rt.db.GetUsername(reqUid)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+		returnErrorResponse(w, http.StatusNotFound, "User not found")
 		ctx.Logger.WithError(err).Error("Database fail")
 		return
 	}
@@ -56,7 +52,6 @@ func (rt *_router) getUsername(w http.ResponseWriter, r *http.Request, ps httpro
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Error encoding response"})
+		returnErrorResponse(w, http.StatusInternalServerError, "Error encoding response")
 	}
 }
@@ -2,11 +2,53 @@ package api
 
 import (
 	"net/http"
+	"strconv"
 	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// leaveGroup removes the user from a group. Their settings and starred messages in the group are removed too, and a
+// member_left system message is added to the group. If they were the last admin, the oldest member becomes admin
 func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
 
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	groupChat, err := rt.db.GroupChat(chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the chat")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to leave the group")
+		return
+	}
+	if !groupChat {
+		returnErrorResponse(w, http.StatusForbidden, "You can't leave a private conversation")
+		return
+	}
+
+	if err := rt.db.RemoveChatMember(userId, chatId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to leave the group")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to leave the group")
+		return
+	}
+	rt.addSystemMessage(ctx, chatId, userId, database.SystemMemberLeft, "")
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+const (
+	// loginChallengeTTL is the time the user has to send the second factor after the passphrase
+	loginChallengeTTL = 5 * time.Minute
+
+	// loginChallengeAttempts is the number of wrong codes accepted for a challenge before it's discarded
+	loginChallengeAttempts = 5
+)
+
+// loginChallenge is issued by doLogin when the passphrase is correct but the user has two-factor authentication
+// enabled. The session is granted only when the challenge is completed with a valid code (see doLoginTotp).
+type loginChallenge struct {
+	userId   int
+	expires  time.Time
+	attempts int
+}
+
+// loginChallenges is an in-memory store of pending challenges, keyed by token. Challenges are short-lived, so losing
+// them on restart only means that users have to type their passphrase again.
+type loginChallenges struct {
+	mu         sync.Mutex
+	challenges map[string]*loginChallenge
+}
+
+func newLoginChallenges() *loginChallenges {
+	return &loginChallenges{challenges: make(map[string]*loginChallenge)}
+}
+
+// create issues a new challenge for the user, returning the token and its expiration
+func (lc *loginChallenges) create(userId int) (string, time.Time, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	now := globaltime.Now()
+	expires := now.Add(loginChallengeTTL)
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	// Drop expired challenges, so that the map does not grow forever
+	for t, c := range lc.challenges {
+		if now.After(c.expires) {
+			delete(lc.challenges, t)
+		}
+	}
+	lc.challenges[token] = &loginChallenge{userId: userId, expires: expires}
+	return token, expires, nil
+}
+
+// attempt returns the user of the challenge, counting an attempt. It returns false if the challenge does not exist,
+// expired, or had too many attempts.
+func (lc *loginChallenges) attempt(token string) (int, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	c, ok := lc.challenges[token]
+	if !ok {
+		return 0, false
+	}
+	if globaltime.Now().After(c.expires) || c.attempts >= loginChallengeAttempts {
+		delete(lc.challenges, token)
+		return 0, false
+	}
+	c.attempts++
+	return c.userId, true
+}
+
+// complete removes the challenge once the session has been granted
+func (lc *loginChallenges) complete(token string) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	delete(lc.challenges, token)
+}
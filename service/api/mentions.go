@@ -0,0 +1,149 @@
+package api
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"wasatext/service/database"
+	"wasatext/service/markup"
+	"wasatext/service/utils"
+)
+
+// entityMention is the type of mention entities; the formatting ones are the markup package types
+const entityMention = "mention"
+
+// mentionToken matches `@username` tokens, which must not follow a letter or a digit (so that e-mail addresses are not
+// mentions)
+var mentionToken = regexp.MustCompile(`(?:^|[^a-zA-Z0-9@])(@[a-zA-Z0-9]+)`)
+
+// entity is a typed range of a message text: formatting or a mention. Offset and length are in UTF-16 code units
+type entity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	URL    string `json:"url,omitempty"`    // links
+	UserId int    `json:"userId,omitempty"` // mentioned user
+}
+
+// newEntities returns the formatting and the mentions of a message, by offset (outer entities first)
+func newEntities(m database.ChatMessage) []entity {
+	entities := []entity{}
+	for _, e := range m.Entities {
+		entities = append(entities, entity{Type: e.Type, Offset: e.Offset, Length: e.Length, URL: e.URL})
+	}
+	for _, mention := range m.Mentions {
+		entities = append(entities, entity{
+			Type:   entityMention,
+			Offset: mention.Offset,
+			Length: mention.Length,
+			UserId: mention.UserId,
+		})
+	}
+	sort.SliceStable(entities, func(i, j int) bool {
+		if entities[i].Offset != entities[j].Offset {
+			return entities[i].Offset < entities[j].Offset
+		}
+		return entities[i].Length > entities[j].Length
+	})
+	return entities
+}
+
+// findMentions resolves the `@username` tokens of a message text (as written, with markup) to the members of the
+// chat: the member with that username or, if none, the only member whose username matches ignoring case. Ambiguous
+// tokens and tokens in code spans and links are not mentions. Offsets refer to the plain text
+func (rt *_router) findMentions(chatId int, text string) ([]database.Mention, error) {
+	plainText, entities, err := markup.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	tokens := mentionToken.FindAllStringSubmatchIndex(plainText, -1)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	members, err := rt.db.GetChatMemberProfiles(chatId)
+	if err != nil {
+		return nil, err
+	}
+	exact := make(map[string]int)
+	folded := make(map[string][]int)
+	for _, member := range members {
+		exact[member.Username] = member.ID
+		lower := strings.ToLower(member.Username)
+		folded[lower] = append(folded[lower], member.ID)
+	}
+
+	var mentions []database.Mention
+	for _, token := range tokens {
+		start, end := token[2], token[3]
+		username := plainText[start+1 : end]
+		if !utils.ValidUsername(username) {
+			continue
+		}
+		userId, found := exact[username]
+		if ids := folded[strings.ToLower(username)]; !found && len(ids) == 1 {
+			userId, found = ids[0], true
+		}
+		if !found {
+			continue
+		}
+
+		offset, length := utf16Length(plainText[:start]), utf16Length(plainText[start:end])
+		if insideEntity(offset, length, entities, markup.Code, markup.URL, markup.Link) {
+			continue
+		}
+		mentions = append(mentions, database.Mention{UserId: userId, Offset: offset, Length: length})
+	}
+	return mentions, nil
+}
+
+// insideEntity reports whether the range overlaps an entity of one of the given types
+func insideEntity(offset int, length int, entities []markup.Entity, types ...string) bool {
+	for _, e := range entities {
+		if offset < e.Offset+e.Length && e.Offset < offset+length {
+			for _, t := range types {
+				if e.Type == t {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// utf16Length returns the length of the string in UTF-16 code units
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// mentionEvent is the payload of "mention" real-time events
+type mentionEvent struct {
+	ChatId    int `json:"chatId"`
+	MessageId int `json:"messageId"`
+	SenderId  int `json:"senderId"`
+}
+
+// notifyMentions sends a "mention" event to the users mentioned in a message just sent, even if they muted the chat.
+// Users who blocked the sender are not notified
+func (rt *_router) notifyMentions(chatId int, messageId int, senderId int) {
+	logger := rt.baseLogger.WithField("message-id", messageId)
+	mentioned, err := rt.db.GetMentionedUsers(messageId)
+	if err != nil {
+		logger.WithError(err).Error("can't retrieve the mentioned users")
+		return
+	}
+
+	recipients := make([]int, 0, len(mentioned))
+	for _, userId := range mentioned {
+		blocked, err := rt.db.IsBlocked(userId, senderId)
+		if err != nil {
+			logger.WithError(err).Error("can't check blocked users")
+			return
+		}
+		if !blocked {
+			recipients = append(recipients, userId)
+		}
+	}
+	rt.hub.Publish("mention", mentionEvent{ChatId: chatId, MessageId: messageId, SenderId: senderId}, recipients...)
+}
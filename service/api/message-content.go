@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image/gif"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wasatext/service/markup"
+)
+
+const (
+	// maxMessageLength is the maximum length (in characters) of a text message
+	maxMessageLength = 2000
+
+	// maxGifSize is the maximum size of an uploaded GIF
+	maxGifSize = 10 << 20
+)
+
+// messageContent is the content of a message being sent: either a text or a GIF, with the time it must be sent at
+// (zero to send it now)
+type messageContent struct {
+	Text   string
+	Photo  []byte
+	SendAt time.Time
+}
+
+// readMessageContent reads the content of a message from the request body. A GIF is sent with the image/gif content
+// type; otherwise the body is JSON, either the text itself (a string) or an object with the text in `textContent`.
+// The sending time is in the `sendAt` field of the object, or in the `sendAt` query parameter.
+func readMessageContent(w http.ResponseWriter, r *http.Request) (messageContent, error) {
+	var sendAt time.Time
+	if value := r.URL.Query().Get("sendAt"); value != "" {
+		var err error
+		if sendAt, err = time.Parse(time.RFC3339, value); err != nil {
+			return messageContent{}, errors.New("Invalid sendAt")
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "image/gif" {
+		photo, err := readGif(w, r)
+		return messageContent{Photo: photo, SendAt: sendAt}, err
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return messageContent{}, errors.New("Invalid JSON provided")
+	}
+
+	content := messageContent{SendAt: sendAt}
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte(`"`)) {
+		if err := json.Unmarshal(raw, &content.Text); err != nil {
+			return messageContent{}, errors.New("Invalid JSON provided")
+		}
+	} else {
+		var body struct {
+			TextContent string     `json:"textContent"`
+			SendAt      *time.Time `json:"sendAt"`
+		}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			return messageContent{}, errors.New("Invalid JSON provided")
+		}
+		content.Text = body.TextContent
+		if body.SendAt != nil {
+			content.SendAt = *body.SendAt
+		}
+	}
+
+	if err := validateMessageText(content.Text); err != nil {
+		return messageContent{}, err
+	}
+	return content, nil
+}
+
+// validateMessageText checks that the text of a message is not empty nor too long, and that its formatting is valid
+func validateMessageText(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("The message is empty")
+	}
+	if utf8.RuneCountInString(text) > maxMessageLength {
+		return errors.New("The message is too long")
+	}
+	if _, _, err := markup.Parse(text); err != nil {
+		return errors.New("Invalid formatting: " + err.Error())
+	}
+	return nil
+}
+
+// readGif reads a GIF from the request body, checking its size and format
+func readGif(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGifSize))
+	if err != nil {
+		return nil, errors.New("The GIF is too large")
+	}
+	if _, err := gif.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return nil, errors.New("Invalid GIF")
+	}
+	return data, nil
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/globaltime"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// minMessageTimer and maxMessageTimer limit how long messages of chats with a timer last
+	minMessageTimer = 5 * time.Second
+	maxMessageTimer = 365 * 24 * time.Hour
+
+	// messageSweepInterval is the interval between deletions of the expired messages
+	messageSweepInterval = 10 * time.Second
+
+	// messageSweepBatch is the maximum number of expired messages deleted in one transaction
+	messageSweepBatch = 500
+)
+
+// setMessageTimer sets how long the new messages of the chat last before disappearing (`{"seconds": 0}` disables
+// the timer). It can be changed by admins in groups, and by both members in private chats. Messages already sent keep
+// their expiration
+func (rt *_router) setMessageTimer(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+
+	var reqBody struct {
+		Seconds *int64 `json:"seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil || reqBody.Seconds == nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	timer := time.Duration(*reqBody.Seconds) * time.Second
+	if *reqBody.Seconds != 0 && (timer < minMessageTimer || timer > maxMessageTimer) {
+		returnErrorResponse(w, http.StatusBadRequest, "The timer must be 0 (disabled) or between 5 seconds and a year")
+		return
+	}
+
+	isMember, canManage, err := rt.canManageChat(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the chat")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if !canManage {
+		returnErrorResponse(w, http.StatusForbidden, "Only admins can change the message timer")
+		return
+	}
+
+	if err := rt.db.SetMessageTimer(chatId, timer); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to set the message timer")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to set the message timer")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// messageSweeper periodically deletes the expired messages, until Close is called. Expired messages are hidden from
+// every read anyway, so the interval only affects how long they stay in the database
+func (rt *_router) messageSweeper() {
+	defer rt.background.Done()
+
+	ticker := time.NewTicker(messageSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.done:
+			return
+		case <-ticker.C:
+			rt.sweepExpiredMessages()
+		}
+	}
+}
+
+// sweepExpiredMessages deletes the expired messages, in batches
+func (rt *_router) sweepExpiredMessages() {
+	for {
+		deleted, err := rt.db.DeleteExpiredMessages(globaltime.Now(), messageSweepBatch)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("can't delete the expired messages")
+			return
+		}
+		if deleted < messageSweepBatch {
+			return
+		}
+
+		select {
+		case <-rt.done:
+			return
+		default:
+		}
+	}
+}
@@ -4,24 +4,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 func (rt *_router) newChat(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	// Checking for auth (bearer token)
-	authHeader := r.Header.Get("Authorization")
-	const bearerPrefix = "Bearer "
-	if len(authHeader) <= len(bearerPrefix) || authHeader[:len(bearerPrefix)] != bearerPrefix {
-		w.WriteHeader(http.StatusUnauthorized)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid authorization format"})
-		return
-	}
-
-	token := authHeader[len(bearerPrefix):]
-	if token == "" {
-		w.WriteHeader(http.StatusUnauthorized)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Empty token"})
+	callerId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
 		return
 	}
 
@@ -30,58 +21,67 @@ func (rt *_router) newChat(w http.ResponseWriter, r *http.Request, ps httprouter
 		Members []int `json:"members"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON provided"})
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
 		return
 	}
 
 	// Checking for any members
 	if len(reqBody.Members) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: Members"})
+		returnErrorResponse(w, http.StatusBadRequest, "Missing required field: Members")
 		return
 	}
 
 	// Verifying if the member count isn't > than the max size declared in the API
 	if len(reqBody.Members) > 2000 {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Too many user IDs provided"})
+		returnErrorResponse(w, http.StatusBadRequest, "Too many user IDs provided")
+		return
+	}
+
+	// Users can create chats only with themselves among the members: nobody can put two other users in a private chat
+	isMember := false
+	for _, member := range reqBody.Members {
+		isMember = isMember || member == callerId
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusBadRequest, "The members must include the creator of the conversation")
+		return
+	}
+
+	// Blocked users can't be put in a chat: the error is the same as for missing users, so that blocks stay private
+	blocked, err := rt.blockedMembers(callerId, reqBody.Members)
+	if err != nil {
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to create conversation")
+		ctx.Logger.WithError(err).Error("Database fail")
+		return
+	}
+	if blocked {
+		returnErrorResponse(w, http.StatusNotFound, "User not found")
 		return
 	}
 
 	var chatId int
-	var err error
 
 	// Verifying the number of users (Treating the case for a private or a group chat)
 	if len(reqBody.Members) == 2 {
-		user1, err := rt.db.GetUsername(reqBody.Members[0])
-		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
-			ctx.Logger.WithError(err).Error("Database fail")
-			return
-		}
-
-		user2, err := rt.db.GetUsername(reqBody.Members[1])
-		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
-			ctx.Logger.WithError(err).Error("Database fail")
-			return
+		for _, member := range reqBody.Members {
+			if _, err := rt.db.GetUsername(member); err != nil {
+				returnErrorResponse(w, http.StatusNotFound, "User not found")
+				ctx.Logger.WithError(err).Error("Database fail")
+				return
+			}
 		}
 
-		chatId, err = rt.db.NewChat("Chat between "+user1+" and "+user2, false)
+		// Private chats have no name: each member sees the other one's name (see chatName)
+		chatId, err = rt.db.NewChat("", false)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create conversation"})
+			returnErrorResponse(w, http.StatusInternalServerError, "Failed to create conversation")
 			ctx.Logger.WithError(err).Error("Database fail")
 			return
 		}
 	} else {
 		chatId, err = rt.db.NewChat("Group chat", true)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create conversation"})
+			returnErrorResponse(w, http.StatusInternalServerError, "Failed to create conversation")
 			ctx.Logger.WithError(err).Error("Database fail")
 			return
 		}
@@ -91,8 +91,16 @@ func (rt *_router) newChat(w http.ResponseWriter, r *http.Request, ps httprouter
 	for _, userId := range reqBody.Members {
 		err := rt.db.AddChatMember(userId, chatId)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "User not found"})
+			returnErrorResponse(w, http.StatusNotFound, "User not found")
+			ctx.Logger.WithError(err).Error("Database fail")
+			return
+		}
+	}
+
+	// The creator of a group is its admin
+	if len(reqBody.Members) != 2 {
+		if err := rt.db.SetChatMemberRole(callerId, chatId, database.RoleAdmin); err != nil {
+			returnErrorResponse(w, http.StatusInternalServerError, "Failed to create conversation")
 			ctx.Logger.WithError(err).Error("Database fail")
 			return
 		}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/globaltime"
+	"wasatext/service/oidc"
+	"wasatext/service/utils"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// oidcRequestTTL is the time the user has to complete the login on the identity provider
+const oidcRequestTTL = 10 * time.Minute
+
+// oidcRequest is a pending authorization request, waiting for the callback
+type oidcRequest struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// oidcRequests is an in-memory store of pending authorization requests, keyed by state
+type oidcRequests struct {
+	mu       sync.Mutex
+	requests map[string]oidcRequest
+}
+
+func newOidcRequests() *oidcRequests {
+	return &oidcRequests{requests: make(map[string]oidcRequest)}
+}
+
+func (o *oidcRequests) add(auth oidc.AuthRequest) {
+	now := globaltime.Now()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Drop expired requests, so that the map does not grow forever
+	for state, req := range o.requests {
+		if now.After(req.expires) {
+			delete(o.requests, state)
+		}
+	}
+	o.requests[auth.State] = oidcRequest{nonce: auth.Nonce, verifier: auth.Verifier, expires: now.Add(oidcRequestTTL)}
+}
+
+// take returns and removes the request for the state: each state can be used only once
+func (o *oidcRequests) take(state string) (oidcRequest, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	req, ok := o.requests[state]
+	delete(o.requests, state)
+	if !ok || globaltime.Now().After(req.expires) {
+		return oidcRequest{}, false
+	}
+	return req, true
+}
+
+// doLoginOidc starts the OpenID Connect login, redirecting the browser to the identity provider
+func (rt *_router) doLoginOidc(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if rt.oidc == nil {
+		returnErrorResponse(w, http.StatusNotFound, "OpenID Connect login is not configured")
+		return
+	}
+
+	auth, err := rt.oidc.NewAuthRequest(r.Context())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to create the OpenID Connect request")
+		returnErrorResponse(w, http.StatusBadGateway, "Identity provider unavailable")
+		return
+	}
+	rt.oidcRequests.add(auth)
+
+	http.Redirect(w, r, auth.URL, http.StatusFound)
+}
+
+// oidcCallback completes the OpenID Connect login: the authorization code is exchanged for the identity, which is
+// mapped to a user (created if needed). The session is sent to the configured post-login URL (in the fragment), or
+// returned as JSON like doLogin.
+func (rt *_router) oidcCallback(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if rt.oidc == nil {
+		returnErrorResponse(w, http.StatusNotFound, "OpenID Connect login is not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		ctx.Logger.WithField("error", errCode).Info("OpenID Connect login refused by the provider")
+		returnErrorResponse(w, http.StatusUnauthorized, "Login refused by the identity provider")
+		return
+	}
+
+	req, ok := rt.oidcRequests.take(query.Get("state"))
+	if !ok {
+		returnErrorResponse(w, http.StatusBadRequest, "Login request expired, please try again")
+		return
+	}
+
+	identity, err := rt.oidc.Exchange(r.Context(), query.Get("code"), req.verifier, req.nonce)
+	if err != nil {
+		ctx.Logger.WithError(err).Warning("OpenID Connect code exchange failed")
+		returnErrorResponse(w, http.StatusUnauthorized, "Login failed")
+		return
+	}
+
+	userId, err := rt.db.GetUserIdByOidcSubject(identity.Issuer, identity.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		userId, err = rt.provisionOidcUser(identity)
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to map the OpenID Connect identity to a user")
+		returnErrorResponse(w, http.StatusInternalServerError, "Error retrieving user")
+		return
+	}
+
+	username, err := rt.db.GetUsername(userId)
+	if err != nil {
+		returnErrorResponse(w, http.StatusInternalServerError, "Error retrieving username")
+		return
+	}
+	apiKey, err := rt.db.GetUserKey(userId)
+	if err != nil {
+		returnErrorResponse(w, http.StatusInternalServerError, "Error retrieving API key")
+		return
+	}
+
+	if rt.oidcPostLoginURL != "" {
+		// The fragment is not sent to servers, so the key does not end up in logs
+		fragment := url.Values{}
+		fragment.Set("userId", strconv.Itoa(userId))
+		fragment.Set("username", username)
+		fragment.Set("apiKey", apiKey)
+		http.Redirect(w, r, rt.oidcPostLoginURL+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	response := struct {
+		Username string `json:"username"`
+		UserId   int    `json:"userId"`
+		APIKey   string `json:"apiKey"`
+	}{
+		Username: username,
+		UserId:   userId,
+		APIKey:   apiKey,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// provisionOidcUser creates the user for a new OpenID Connect identity. The username is derived from the identity
+// claims (preferred username, e-mail or name) following the utils.ValidUsername rules, with a numeric suffix if taken.
+func (rt *_router) provisionOidcUser(identity oidc.Identity) (int, error) {
+	name := identity.PreferredUsername
+	if name == "" && identity.Email != "" {
+		name = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	if name == "" {
+		name = identity.Name
+	}
+	base := utils.SanitizeUsername(name)
+
+	apiKey, err := generateApiKey()
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 1; i < 1000; i++ {
+		username := base
+		if i > 1 {
+			suffix := strconv.Itoa(i)
+			if len(base)+len(suffix) > 16 {
+				username = base[:16-len(suffix)]
+			}
+			username += suffix
+		}
+		if !utils.ValidUsername(username) {
+			return 0, fmt.Errorf("invalid username generated: %q", username)
+		}
+
+		exists, err := rt.db.UserExists(username)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return rt.db.CreateOidcUser(username, apiKey, identity.Issuer, identity.Subject)
+		}
+	}
+	return 0, fmt.Errorf("no username available for %q", base)
+}
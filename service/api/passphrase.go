@@ -0,0 +1,19 @@
+package api
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassphrase returns the bcrypt hash of the passphrase, to be stored in the database
+func hashPassphrase(passphrase string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(passphrase), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassphrase reports whether the passphrase matches the bcrypt hash
+func checkPassphrase(hash string, passphrase string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passphrase)) == nil
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// pinEvent is the payload of "pin" real-time events, sent to the chat members when a message is pinned or unpinned
+type pinEvent struct {
+	ChatId    int  `json:"chatId"`
+	MessageId int  `json:"messageId"`
+	UserId    int  `json:"userId"`
+	Pinned    bool `json:"pinned"`
+}
+
+// getPinnedMessages returns the pinned messages of a chat, most recently pinned first
+func (rt *_router) getPinnedMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	pins, err := rt.db.GetPinnedMessages(chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the pinned messages")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the pinned messages")
+		return
+	}
+
+	type pinnedMessage struct {
+		messageResponse
+		PinnedBy int       `json:"pinnedBy"`
+		PinnedAt time.Time `json:"pinnedAt"`
+	}
+	response := []pinnedMessage{}
+	for _, p := range pins {
+		response = append(response, pinnedMessage{
+			messageResponse: newMessageResponse(p.ChatMessage, userId),
+			PinnedBy:        p.PinnedBy,
+			PinnedAt:        p.PinnedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"pins": response})
+}
+
+// pinMessage pins a message of the chat. Only admins can pin messages in groups; in private chats both members can.
+// System messages can't be pinned
+func (rt *_router) pinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.pinParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+	if message.System != nil {
+		returnErrorResponse(w, http.StatusForbidden, "System messages can't be pinned")
+		return
+	}
+	chatId, messageId := message.ChatId, message.ID
+
+	pinned, err := rt.db.PinMessage(chatId, messageId, userId, rt.maxPinnedMessages)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to pin the message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to pin the message")
+		return
+	}
+	if !pinned {
+		returnErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("At most %d messages can be pinned", rt.maxPinnedMessages))
+		return
+	}
+	rt.publishPin(pinEvent{ChatId: chatId, MessageId: messageId, UserId: userId, Pinned: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unpinMessage unpins a message of the chat, with the same permissions as pinMessage
+func (rt *_router) unpinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.pinParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+	chatId, messageId := message.ChatId, message.ID
+
+	wasPinned, err := rt.db.UnpinMessage(chatId, messageId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to unpin the message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to unpin the message")
+		return
+	}
+	if wasPinned {
+		rt.publishPin(pinEvent{ChatId: chatId, MessageId: messageId, UserId: userId, Pinned: false})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pinParams authorizes the user, parses the `chatId` and `messageId` path parameters and checks that the user can
+// manage the pins of the chat, and that the message is in the chat. It returns the user and the message
+func (rt *_router) pinParams(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (int, database.ChatMessage, bool) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return 0, database.ChatMessage{}, false
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return 0, database.ChatMessage{}, false
+	}
+	messageId, err := strconv.Atoi(ps.ByName("messageId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid message id")
+		return 0, database.ChatMessage{}, false
+	}
+
+	isMember, canManage, err := rt.canManageChat(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the chat")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return 0, database.ChatMessage{}, false
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return 0, database.ChatMessage{}, false
+	}
+	if rt.maxPinnedMessages <= 0 || !canManage {
+		returnErrorResponse(w, http.StatusForbidden, "You can't manage the pinned messages of this conversation")
+		return 0, database.ChatMessage{}, false
+	}
+
+	message, err := rt.db.GetChatMessage(chatId, messageId)
+	if errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "Message not found")
+		return 0, database.ChatMessage{}, false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return 0, database.ChatMessage{}, false
+	}
+	return userId, message, true
+}
+
+// canManageChat reports whether the user is a member of the chat, and whether they can manage it: admins in groups,
+// both members in private chats
+func (rt *_router) canManageChat(userId int, chatId int) (bool, bool, error) {
+	role, err := rt.db.GetChatMemberRole(userId, chatId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	groupChat, err := rt.db.GroupChat(chatId)
+	if err != nil {
+		return false, false, err
+	}
+	return true, !groupChat || role == database.RoleAdmin, nil
+}
+
+// publishPin sends the pin change to the members of the chat (the user who made it included, for their other
+// sessions)
+func (rt *_router) publishPin(event pinEvent) {
+	members, err := rt.db.GetChatMembers(event.ChatId)
+	if err != nil {
+		rt.baseLogger.WithError(err).WithField("chat-id", event.ChatId).Error("can't retrieve the chat members")
+		return
+	}
+	rt.hub.Publish("pin", event, members...)
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+	"wasatext/service/globaltime"
+	"wasatext/service/utils"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// maxPollQuestionLength and maxPollOptionLength limit the length (in characters) of the question and the options
+	maxPollQuestionLength = 300
+	maxPollOptionLength   = 100
+)
+
+// pollResponse is the poll of a message, with the tallies
+type pollResponse struct {
+	Options        []pollOptionResponse `json:"options"`
+	MultipleChoice bool                 `json:"multipleChoice"`
+	Anonymous      bool                 `json:"anonymous"`
+	ClosesAt       *time.Time           `json:"closesAt,omitempty"`
+	Closed         bool                 `json:"closed"`
+	TotalVoters    int                  `json:"totalVoters"`
+	MyVotes        []int                `json:"myVotes"`
+}
+
+// pollOptionResponse is an option of a poll, with its votes. Voters are listed only if votes are visible
+type pollOptionResponse struct {
+	OptionId int    `json:"optionId"`
+	Text     string `json:"text"`
+	Votes    int    `json:"votes"`
+	Voters   []int  `json:"voters,omitempty"`
+}
+
+// newPollResponse returns the poll as seen by the user
+func newPollResponse(p database.Poll, userId int) pollResponse {
+	response := pollResponse{
+		Options:        []pollOptionResponse{},
+		MultipleChoice: p.MultipleChoice,
+		Anonymous:      p.Anonymous,
+		Closed:         p.Closed(globaltime.Now()),
+		MyVotes:        []int{},
+	}
+	if !p.ClosesAt.IsZero() {
+		closesAt := p.ClosesAt
+		response.ClosesAt = &closesAt
+	}
+
+	voters := make(map[int]bool)
+	for i, option := range p.Options {
+		optionResponse := pollOptionResponse{OptionId: i, Text: option.Text, Votes: len(option.Voters)}
+		if !p.Anonymous {
+			optionResponse.Voters = option.Voters
+		}
+		for _, voter := range option.Voters {
+			voters[voter] = true
+			if voter == userId {
+				response.MyVotes = append(response.MyVotes, i)
+			}
+		}
+		response.Options = append(response.Options, optionResponse)
+	}
+	response.TotalVoters = len(voters)
+	return response
+}
+
+// pollEvent is the payload of "poll" real-time events, sent to the chat members when the votes of a poll change or
+// it's closed. UserId is the user who voted or closed the poll, omitted for votes in anonymous polls
+type pollEvent struct {
+	ChatId      int   `json:"chatId"`
+	MessageId   int   `json:"messageId"`
+	UserId      int   `json:"userId,omitempty"`
+	Votes       []int `json:"votes"` // for each option
+	TotalVoters int   `json:"totalVoters"`
+	Closed      bool  `json:"closed"`
+}
+
+// createPoll sends a poll in the chat: a message whose text is the question, with 2 to 10 options voted by the
+// members. Votes can be anonymous, and the poll can close by itself at a given time
+func (rt *_router) createPoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	var reqBody struct {
+		Question       string     `json:"question"`
+		Options        []string   `json:"options"`
+		MultipleChoice bool       `json:"multipleChoice"`
+		Anonymous      bool       `json:"anonymous"`
+		ClosesAt       *time.Time `json:"closesAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	if err := validateMessageText(reqBody.Question); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if utf8.RuneCountInString(reqBody.Question) > maxPollQuestionLength {
+		returnErrorResponse(w, http.StatusBadRequest, "The question is too long")
+		return
+	}
+	if len(reqBody.Options) < database.MinPollOptions || len(reqBody.Options) > database.MaxPollOptions {
+		returnErrorResponse(w, http.StatusBadRequest, "A poll has between 2 and 10 options")
+		return
+	}
+	seen := make(map[string]bool)
+	for _, option := range reqBody.Options {
+		if strings.TrimSpace(option) == "" || !utils.ValidProfileText(option, maxPollOptionLength, false) {
+			returnErrorResponse(w, http.StatusBadRequest, "Options must be up to 100 characters, without control characters")
+			return
+		}
+		if seen[option] {
+			returnErrorResponse(w, http.StatusBadRequest, "Options must be different")
+			return
+		}
+		seen[option] = true
+	}
+	var closesAt time.Time
+	if reqBody.ClosesAt != nil {
+		if !validSendAt(*reqBody.ClosesAt) {
+			returnErrorResponse(w, http.StatusBadRequest, "closesAt must be in the future, within a year")
+			return
+		}
+		closesAt = *reqBody.ClosesAt
+	}
+
+	// In private chats, nobody can send messages when either user blocked the other one. The response is the same as
+	// for a missing chat, so that users can't find out who blocked them
+	if allowed, err := rt.canMessagePrivately(userId, chatId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check blocked users")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	} else if !allowed {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	mentions, err := rt.findMentions(chatId, reqBody.Question)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to find the mentions")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to send the poll")
+		return
+	}
+	messageId, err := rt.db.CreatePoll(chatId, userId, reqBody.Question, reqBody.Options, reqBody.MultipleChoice,
+		reqBody.Anonymous, closesAt, globaltime.Now(), mentions)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to send the poll")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to send the poll")
+		return
+	}
+	rt.messageSent(chatId, messageId, userId)
+
+	message, err := rt.db.GetChatMessage(chatId, messageId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the poll")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the poll")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(newMessageResponse(message, userId))
+}
+
+// votePoll replaces the votes of the user in an open poll: one option, or any number of them in multiple choice
+// polls
+func (rt *_router) votePoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.openPoll(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		OptionIds []int `json:"optionIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	if len(reqBody.OptionIds) == 0 || len(reqBody.OptionIds) > 1 && !message.Poll.MultipleChoice {
+		returnErrorResponse(w, http.StatusBadRequest, "Vote one option, or more in multiple choice polls")
+		return
+	}
+	seen := make(map[int]bool)
+	for _, optionId := range reqBody.OptionIds {
+		if optionId < 0 || optionId >= len(message.Poll.Options) || seen[optionId] {
+			returnErrorResponse(w, http.StatusBadRequest, "Invalid option")
+			return
+		}
+		seen[optionId] = true
+	}
+
+	// The poll can be closed in the meantime: the vote is recorded only if it's still open
+	voted, err := rt.db.VotePoll(message.ID, userId, reqBody.OptionIds, globaltime.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to vote")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to vote")
+		return
+	}
+	if !voted {
+		returnErrorResponse(w, http.StatusForbidden, "The poll is closed")
+		return
+	}
+	rt.sendPollUpdate(w, ctx, message, userId, !message.Poll.Anonymous)
+}
+
+// retractPollVote removes the votes of the user from an open poll
+func (rt *_router) retractPollVote(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.openPoll(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	// The poll can be closed in the meantime: the votes are retracted only if it's still open
+	retracted, err := rt.db.RetractPollVote(message.ID, userId, globaltime.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retract the vote")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retract the vote")
+		return
+	}
+	if !retracted {
+		returnErrorResponse(w, http.StatusForbidden, "The poll is closed")
+		return
+	}
+	rt.sendPollUpdate(w, ctx, message, userId, !message.Poll.Anonymous)
+}
+
+// closePoll closes a poll before its close time. Polls can be closed by who sent them, and by the admins of groups
+func (rt *_router) closePoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.visibleMessage(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+	if message.Poll == nil {
+		returnErrorResponse(w, http.StatusNotFound, "Poll not found")
+		return
+	}
+
+	if message.SenderId != userId {
+		_, canManage, err := rt.canManageChat(userId, message.ChatId)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("Failed to retrieve the chat")
+			returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if !canManage {
+			returnErrorResponse(w, http.StatusForbidden, "Only who sent the poll and admins can close it")
+			return
+		}
+	}
+
+	closed, err := rt.db.ClosePoll(message.ID, globaltime.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to close the poll")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to close the poll")
+		return
+	}
+	if !closed {
+		// Already closed: nothing changed
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(newMessageResponse(message, userId))
+		return
+	}
+	rt.sendPollUpdate(w, ctx, message, userId, true)
+}
+
+// openPoll returns the poll identified by the path parameters, if it's open. If not, an error is sent to the client
+// and false is returned
+func (rt *_router) openPoll(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (int, database.ChatMessage, bool) {
+	userId, message, ok := rt.visibleMessage(w, r, ps, ctx)
+	if !ok {
+		return 0, database.ChatMessage{}, false
+	}
+	if message.Poll == nil {
+		returnErrorResponse(w, http.StatusNotFound, "Poll not found")
+		return 0, database.ChatMessage{}, false
+	}
+	if message.Poll.Closed(globaltime.Now()) {
+		returnErrorResponse(w, http.StatusForbidden, "The poll is closed")
+		return 0, database.ChatMessage{}, false
+	}
+	return userId, message, true
+}
+
+// sendPollUpdate sends the updated poll to the client, and the new tallies to the chat members. The user is named in
+// the event only if showUser is true
+func (rt *_router) sendPollUpdate(w http.ResponseWriter, ctx reqcontext.RequestContext, message database.ChatMessage, userId int, showUser bool) {
+	message, err := rt.db.GetChatMessage(message.ChatId, message.ID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the poll")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the poll")
+		return
+	}
+	response := newMessageResponse(message, userId)
+
+	event := pollEvent{
+		ChatId:      message.ChatId,
+		MessageId:   message.ID,
+		Votes:       []int{},
+		TotalVoters: response.Poll.TotalVoters,
+		Closed:      response.Poll.Closed,
+	}
+	if showUser {
+		event.UserId = userId
+	}
+	for _, option := range response.Poll.Options {
+		event.Votes = append(event.Votes, option.Votes)
+	}
+	if members, err := rt.db.GetChatMembers(message.ChatId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the chat members")
+	} else {
+		rt.hub.Publish("poll", event, members...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
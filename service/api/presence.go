@@ -0,0 +1,210 @@
+package api
+
+import (
+	"sync"
+	"time"
+	"wasatext/service/database"
+	"wasatext/service/globaltime"
+)
+
+const (
+	// presenceWindow is how long users are considered online after their last authenticated request (or after their
+	// last real-time connection is closed)
+	presenceWindow = time.Minute
+
+	// presenceJanitorInterval is the interval between checks for users going offline
+	presenceJanitorInterval = 10 * time.Second
+)
+
+// presence tracks which users are online: users with a real-time connection, or who made an authenticated request in
+// the last presenceWindow. The last seen timestamp is saved in the database when they go offline.
+type presence struct {
+	mu         sync.Mutex
+	lastActive map[int]time.Time
+	online     map[int]struct{}
+
+	// arrived are the users who came online and are still to be announced by the janitor, woken up by wake
+	arrived map[int]struct{}
+	wake    chan struct{}
+}
+
+func newPresence() *presence {
+	return &presence{
+		lastActive: make(map[int]time.Time),
+		online:     make(map[int]struct{}),
+		arrived:    make(map[int]struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// presenceEvent is the payload of "presence" real-time events
+type presenceEvent struct {
+	UserId   int        `json:"userId"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+// seen records an activity of the user. It runs on every authenticated request, so users who weren't online are
+// announced by the janitor instead, which needs the database
+func (rt *_router) seen(userId int) {
+	rt.presence.mu.Lock()
+	rt.presence.lastActive[userId] = globaltime.Now()
+	if _, wasOnline := rt.presence.online[userId]; !wasOnline {
+		rt.presence.online[userId] = struct{}{}
+		rt.presence.arrived[userId] = struct{}{}
+		select {
+		case rt.presence.wake <- struct{}{}:
+		default:
+			// The janitor is already going to announce them
+		}
+	}
+	rt.presence.mu.Unlock()
+}
+
+// online reports whether the user is online, and when they were last active
+func (rt *_router) online(userId int) (bool, time.Time) {
+	now := globaltime.Now()
+	if rt.hub.Connected(userId) {
+		return true, now
+	}
+
+	rt.presence.mu.Lock()
+	lastActive, ok := rt.presence.lastActive[userId]
+	rt.presence.mu.Unlock()
+	return ok && now.Sub(lastActive) < presenceWindow, lastActive
+}
+
+// presenceVisible reports whether the viewer can see the online state and the last seen timestamp of the user,
+// according to the privacy setting of the user. Blocked users (in either direction) never see them.
+func (rt *_router) presenceVisible(user database.UserInfo, viewerId int) (bool, error) {
+	if user.ID == viewerId {
+		return true, nil
+	}
+	if user.Deleted {
+		return false, nil
+	}
+	if blocked, err := rt.db.BlockedBetween(user.ID, viewerId); err != nil || blocked {
+		return false, err
+	}
+
+	switch user.LastSeenPrivacy {
+	case database.PrivacyEveryone:
+		return true, nil
+	case database.PrivacyContacts:
+		return rt.isContact(user.ID, viewerId)
+	default:
+		return false, nil
+	}
+}
+
+// isContact reports whether otherId is in the contact list of userId
+func (rt *_router) isContact(userId int, otherId int) (bool, error) {
+	return rt.db.IsContact(userId, otherId)
+}
+
+// publishPresence sends the presence change of the user to the members of the chats they share, if they can see it
+func (rt *_router) publishPresence(userId int, online bool, lastSeen time.Time) {
+	logger := rt.baseLogger.WithField("user-id", userId)
+
+	user, err := rt.db.GetUser(userId)
+	if err != nil {
+		logger.WithError(err).Error("can't retrieve the user to publish their presence")
+		return
+	}
+	viewers, err := rt.db.GetSharedChatUsers(userId)
+	if err != nil {
+		logger.WithError(err).Error("can't retrieve the users to publish the presence to")
+		return
+	}
+
+	var recipients []int
+	for _, viewerId := range viewers {
+		visible, err := rt.presenceVisible(user, viewerId)
+		if err != nil {
+			logger.WithError(err).Error("can't check the presence visibility")
+			return
+		}
+		if visible {
+			recipients = append(recipients, viewerId)
+		}
+	}
+
+	event := presenceEvent{UserId: userId, Online: online}
+	if !online {
+		event.LastSeen = &lastSeen
+	}
+	rt.hub.Publish("presence", event, recipients...)
+}
+
+// presenceJanitor announces the users coming online and, periodically, the users going offline, saving their last
+// seen timestamp, until Close is called. It also drops the expired real-time events.
+func (rt *_router) presenceJanitor() {
+	defer rt.background.Done()
+
+	ticker := time.NewTicker(presenceJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.done:
+			rt.savePresence(true)
+			return
+		case <-rt.presence.wake:
+			rt.announceArrived()
+		case <-ticker.C:
+			rt.hub.Cleanup()
+			rt.savePresence(false)
+		}
+	}
+}
+
+// announceArrived announces the users who came online since the last call
+func (rt *_router) announceArrived() {
+	rt.presence.mu.Lock()
+	arrived := rt.presence.arrived
+	rt.presence.arrived = make(map[int]struct{})
+	rt.presence.mu.Unlock()
+
+	for userId := range arrived {
+		rt.publishPresence(userId, true, time.Time{})
+	}
+}
+
+// savePresence saves the last seen timestamp of the users who went offline (or of every online user, when the server
+// is shutting down), announcing them as offline
+func (rt *_router) savePresence(shutdown bool) {
+	type offline struct {
+		userId   int
+		lastSeen time.Time
+	}
+	var gone []offline
+
+	rt.presence.mu.Lock()
+	for userId := range rt.presence.online {
+		lastActive := rt.presence.lastActive[userId]
+		if shutdown || !rt.stillOnline(userId, lastActive) {
+			gone = append(gone, offline{userId: userId, lastSeen: lastActive})
+			delete(rt.presence.online, userId)
+			delete(rt.presence.lastActive, userId)
+			delete(rt.presence.arrived, userId)
+		}
+	}
+	rt.presence.mu.Unlock()
+
+	for _, u := range gone {
+		if err := rt.db.SetLastSeen(u.userId, u.lastSeen); err != nil {
+			rt.baseLogger.WithError(err).WithField("user-id", u.userId).Error("can't save the last seen timestamp")
+		}
+		if !shutdown {
+			rt.publishPresence(u.userId, false, u.lastSeen)
+		}
+	}
+}
+
+// stillOnline reports whether a user with the given last activity is still online. The presence lock must be held
+func (rt *_router) stillOnline(userId int, lastActive time.Time) bool {
+	if rt.hub.Connected(userId) {
+		return true
+	}
+	return globaltime.Now().Sub(lastActive) < presenceWindow
+}
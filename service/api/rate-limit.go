@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/metrics"
+	"wasatext/service/ratelimit"
+	"wasatext/service/utils"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// Route groups sharing the same rate limit
+const (
+	rateLimitLogin    = "login"
+	rateLimitMessages = "messages"
+	rateLimitUploads  = "uploads"
+)
+
+// rateLimitJanitorInterval is how often idle buckets are removed and limiter counters are logged
+const rateLimitJanitorInterval = time.Minute
+
+// maxLoginBodyPeek is how much of a login request body is read to find the username it targets
+const maxLoginBodyPeek = 4 << 10
+
+// maxLoginDelay is the longest a login is held back because of the failed logins for its username. It must stay well
+// below the write timeout
+const maxLoginDelay = 2 * time.Second
+
+// RateLimit configures the token bucket of a route group: PerMinute requests are allowed each minute, with bursts up to
+// Burst requests. Limits apply both per client IP address and per user. Failed logins are counted per username too, so
+// that an account can't be brute forced from many addresses: once they exceed the limit, logins for the username are
+// slowed down (not rejected, so that others can't lock the owner out). A zero PerMinute disables the limit.
+type RateLimit struct {
+	PerMinute float64
+	Burst     int
+}
+
+var rateLimited = metrics.NewCounterVec(
+	"wasatext_rate_limited_requests_total",
+	"Number of requests rejected by the rate limiter, partitioned by route group.",
+	"group",
+)
+
+// newRateLimiters creates a limiter for each route group with a limit configured
+func newRateLimiters(cfg Config) map[string]*ratelimit.Limiter {
+	limiters := make(map[string]*ratelimit.Limiter)
+	for group, limit := range map[string]RateLimit{
+		rateLimitLogin:    cfg.LoginRateLimit,
+		rateLimitMessages: cfg.MessageRateLimit,
+		rateLimitUploads:  cfg.UploadRateLimit,
+	} {
+		if limit.PerMinute > 0 {
+			limiters[group] = ratelimit.New(limit.PerMinute, limit.Burst)
+		}
+	}
+	return limiters
+}
+
+// rateLimit returns a handler which applies the rate limit of the route group before calling fn. Rejected requests get
+// HTTP 429, with the number of seconds to wait in the Retry-After header.
+func (rt *_router) rateLimit(group string, fn httpRouterHandler) httpRouterHandler {
+	limiter, ok := rt.limiters[group]
+	if !ok {
+		return fn
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		keys := []string{"ip:" + rt.clientIP(r)}
+		if ctx.UserId != 0 {
+			keys = append(keys, "user:"+strconv.Itoa(ctx.UserId))
+		}
+
+		if allowed, retryAfter := limiter.Allow(keys...); !allowed {
+			rateLimited.Inc(group)
+			ctx.Logger.WithField("group", group).Warning("request rate limited")
+
+			seconds := int64(math.Ceil(retryAfter.Seconds()))
+			if retryAfter > 24*time.Hour {
+				seconds = int64((24 * time.Hour).Seconds())
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+			returnErrorResponse(w, http.StatusTooManyRequests, "Too many requests, please retry later")
+			return
+		}
+
+		if group == rateLimitLogin {
+			if username := loginUsername(r); username != "" {
+				if delay := limiter.Wait("username:" + username); delay > 0 {
+					if delay > maxLoginDelay {
+						delay = maxLoginDelay
+					}
+					ctx.Logger.WithField("delay", delay).Info("login delayed after failed attempts")
+					select {
+					case <-time.After(delay):
+					case <-r.Context().Done():
+						return
+					}
+				}
+			}
+		}
+
+		fn(w, r, ps, ctx)
+	}
+}
+
+// loginFailed counts a failed login for the username, which slows down the next ones (see rateLimit)
+func (rt *_router) loginFailed(username string) {
+	if limiter, ok := rt.limiters[rateLimitLogin]; ok {
+		limiter.Take("username:" + username)
+	}
+}
+
+// loginUsername returns the username targeted by a login request (the `name` field of its JSON body), or an empty
+// string if there's none. The body is restored, so that the handler can read it
+func loginUsername(r *http.Request) string {
+	if r.Method != http.MethodPost || r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxLoginBodyPeek))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return ""
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || !utils.ValidUsername(request.Name) {
+		return ""
+	}
+	return request.Name
+}
+
+// rateLimitJanitor periodically frees the buckets of idle clients and logs the limiter counters, until Close is called
+func (rt *_router) rateLimitJanitor() {
+	defer rt.background.Done()
+
+	ticker := time.NewTicker(rateLimitJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.done:
+			return
+		case <-ticker.C:
+			for group, limiter := range rt.limiters {
+				limiter.Cleanup()
+				allowed, limited, buckets := limiter.Stats()
+				entry := rt.baseLogger.WithFields(logrus.Fields{
+					"group":   group,
+					"allowed": allowed,
+					"limited": limited,
+					"buckets": buckets,
+				})
+				if limited > 0 {
+					entry.Info("rate limiter stats")
+				} else {
+					entry.Debug("rate limiter stats")
+				}
+			}
+		}
+	}
+}
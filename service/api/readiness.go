@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"wasatext/service/database"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// readinessCheck is the result of a single check performed by the readiness probe
+type readinessCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// readiness is an HTTP handler that checks whether the API server can accept traffic. It pings the database, verifies
+// that the schema version is the expected one and that the storage is writable. It replies with HTTP Status 200 if all
+// checks pass, HTTP Status 503 otherwise (also during the graceful shutdown), with a JSON breakdown of each check.
+func (rt *_router) readiness(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	checks := make(map[string]readinessCheck)
+	ready := true
+
+	record := func(name string, err error) {
+		if err != nil {
+			ready = false
+			checks[name] = readinessCheck{Status: "fail", Error: err.Error()}
+			return
+		}
+		checks[name] = readinessCheck{Status: "ok"}
+	}
+
+	if atomic.LoadInt32(&rt.shuttingDown) == 1 {
+		record("shutdown", fmt.Errorf("server is shutting down"))
+	} else {
+		record("shutdown", nil)
+	}
+
+	record("database", rt.db.Ping())
+
+	version, err := rt.db.GetSchemaVersion()
+	if err == nil && version != database.LatestSchemaVersion() {
+		err = fmt.Errorf("schema version is %d, expected %d", version, database.LatestSchemaVersion())
+	}
+	record("schema", err)
+
+	record("storage", rt.db.CheckWritable())
+
+	response := struct {
+		Status string                    `json:"status"`
+		Checks map[string]readinessCheck `json:"checks"`
+	}{
+		Status: "ready",
+		Checks: checks,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		response.Status = "unavailable"
+		rt.baseLogger.WithField("checks", checks).Warning("readiness check failed")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -16,6 +16,13 @@ type RequestContext struct {
 	// ReqUUID is the request unique ID
 	ReqUUID uuid.UUID
 
+	// RequestID is the ID sent back to the client in the X-Request-ID header and used in logs. It's ReqUUID, unless a
+	// trusted reverse proxy already assigned an ID to the request
+	RequestID string
+
+	// UserId is the ID of the user authenticated by the bearer token, or 0 if the request is not authenticated
+	UserId int
+
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
 }
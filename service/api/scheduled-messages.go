@@ -0,0 +1,289 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+	"wasatext/service/globaltime"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// maxScheduleAhead is how far in the future messages can be scheduled
+	maxScheduleAhead = 365 * 24 * time.Hour
+
+	// scheduleDispatchInterval is the interval between checks for scheduled messages to send
+	scheduleDispatchInterval = time.Second
+
+	// scheduleDispatchBatch is the number of scheduled messages read from the database at once
+	scheduleDispatchBatch = 100
+)
+
+// scheduledMessageResponse is a message waiting to be sent
+type scheduledMessageResponse struct {
+	ScheduledId int       `json:"scheduledId"`
+	TextContent string    `json:"textContent,omitempty"`
+	HasPhoto    bool      `json:"hasPhoto"`
+	SendAt      time.Time `json:"sendAt"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func newScheduledMessageResponse(m database.ScheduledMessage) scheduledMessageResponse {
+	return scheduledMessageResponse{
+		ScheduledId: m.ID,
+		TextContent: m.TextContent,
+		HasPhoto:    m.HasPhoto,
+		SendAt:      m.SendAt,
+		CreatedAt:   m.CreatedAt,
+	}
+}
+
+// validSendAt reports whether a message can be scheduled at the given time: in the future, within maxScheduleAhead
+func validSendAt(sendAt time.Time) bool {
+	now := globaltime.Now()
+	return sendAt.After(now) && sendAt.Before(now.Add(maxScheduleAhead))
+}
+
+// scheduleMessage stores a message to be sent later by the dispatcher (see scheduledDispatcher). It's called by
+// sendMessage when the sending time is set, after the checks on the chat. It reports whether the message was scheduled
+func (rt *_router) scheduleMessage(w http.ResponseWriter, ctx reqcontext.RequestContext, userId int, chatId int, content messageContent) bool {
+	if !validSendAt(content.SendAt) {
+		returnErrorResponse(w, http.StatusBadRequest, "sendAt must be in the future, within a year")
+		return false
+	}
+
+	scheduledId, err := rt.db.ScheduleMessage(chatId, userId, content.Text, content.Photo, content.SendAt)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to schedule the message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to schedule the message")
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"scheduledId": scheduledId, "sendAt": content.SendAt})
+	return true
+}
+
+// getScheduledMessages lists the messages scheduled by the user in the chat, first to be sent first
+func (rt *_router) getScheduledMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	messages, err := rt.db.GetScheduledMessages(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the scheduled messages")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the scheduled messages")
+		return
+	}
+	response := []scheduledMessageResponse{}
+	for _, m := range messages {
+		response = append(response, newScheduledMessageResponse(m))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"scheduled": response})
+}
+
+// updateScheduledMessage changes the text (of text messages only) and the sending time of a scheduled message. Only
+// the fields in the request are changed
+func (rt *_router) updateScheduledMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	message, ok := rt.scheduledMessageParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		TextContent *string    `json:"textContent"`
+		SendAt      *time.Time `json:"sendAt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	if reqBody.TextContent != nil {
+		if message.HasPhoto {
+			returnErrorResponse(w, http.StatusBadRequest, "The text of a photo can't be changed")
+			return
+		}
+		if err := validateMessageText(*reqBody.TextContent); err != nil {
+			returnErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if reqBody.SendAt != nil && !validSendAt(*reqBody.SendAt) {
+		returnErrorResponse(w, http.StatusBadRequest, "sendAt must be in the future, within a year")
+		return
+	}
+
+	if err := rt.db.UpdateScheduledMessage(message.ID, reqBody.TextContent, reqBody.SendAt); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to update the scheduled message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to update the scheduled message")
+		return
+	}
+
+	// The message may have been sent in the meantime
+	message, err := rt.db.GetScheduledMessage(message.SenderId, message.ChatId, message.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "Scheduled message not found")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the scheduled message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to update the scheduled message")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(newScheduledMessageResponse(message))
+}
+
+// cancelScheduledMessage deletes a scheduled message before it's sent
+func (rt *_router) cancelScheduledMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	message, ok := rt.scheduledMessageParams(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	if err := rt.db.CancelScheduledMessage(message.ID); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to cancel the scheduled message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to cancel the scheduled message")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// scheduledMessageParams authorizes the user and returns their scheduled message identified by the `chatId` and
+// `scheduledId` path parameters
+func (rt *_router) scheduledMessageParams(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (database.ScheduledMessage, bool) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return database.ScheduledMessage{}, false
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return database.ScheduledMessage{}, false
+	}
+	scheduledId, err := strconv.Atoi(ps.ByName("scheduledId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid scheduled message id")
+		return database.ScheduledMessage{}, false
+	}
+
+	// Only the sender can see their scheduled messages, and they're removed when the sender leaves the chat
+	message, err := rt.db.GetScheduledMessage(userId, chatId, scheduledId)
+	if errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "Scheduled message not found")
+		return database.ScheduledMessage{}, false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the scheduled message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return database.ScheduledMessage{}, false
+	}
+	return message, true
+}
+
+// scheduledDispatcher periodically sends the scheduled messages which are due, until Close is called. Messages due
+// while the server was stopped are sent as soon as it starts again.
+func (rt *_router) scheduledDispatcher() {
+	defer rt.background.Done()
+
+	ticker := time.NewTicker(scheduleDispatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.done:
+			return
+		case <-ticker.C:
+			rt.dispatchScheduledMessages()
+		}
+	}
+}
+
+// dispatchScheduledMessages sends the scheduled messages which are due. Messages which can't be sent anymore (e.g.,
+// as the sender was blocked in a private chat) are dropped. A message failing with an error is skipped, and tried
+// again at the next run: it doesn't hold back the others
+func (rt *_router) dispatchScheduledMessages() {
+	now := globaltime.Now()
+	skipped := 0
+	for {
+		// Sent and dropped messages are removed, so the skipped ones are at the start
+		due, err := rt.db.GetDueScheduledMessages(now, scheduleDispatchBatch, skipped)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("can't retrieve the scheduled messages")
+			return
+		}
+
+		for _, m := range due {
+			select {
+			case <-rt.done:
+				return
+			default:
+			}
+
+			if err := rt.dispatchScheduledMessage(m); err != nil {
+				rt.baseLogger.WithError(err).WithField("scheduled-id", m.ID).Error("can't send the scheduled message")
+				skipped++
+			}
+		}
+
+		if len(due) < scheduleDispatchBatch {
+			return
+		}
+	}
+}
+
+// dispatchScheduledMessage sends a scheduled message, or drops it if the sender can't send messages in the chat
+// anymore
+func (rt *_router) dispatchScheduledMessage(m database.ScheduledMessage) error {
+	allowed, err := rt.canMessagePrivately(m.SenderId, m.ChatId)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		rt.baseLogger.WithField("scheduled-id", m.ID).
+			Info("dropping a scheduled message, as the sender can't send messages in the chat anymore")
+		return rt.db.CancelScheduledMessage(m.ID)
+	}
+
+	mentions, err := rt.findMentions(m.ChatId, m.TextContent)
+	if err != nil {
+		return err
+	}
+	// Not sent if edited in the meantime: the next run sends the new text
+	messageId, sent, err := rt.db.DispatchScheduledMessage(m.ID, m.TextContent, globaltime.Now(), mentions)
+	if err != nil {
+		return err
+	}
+	if sent {
+		rt.messageSent(m.ChatId, messageId, m.SenderId)
+	}
+	return nil
+}
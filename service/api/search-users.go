@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"wasatext/service/api/reqcontext"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// searchQuery matches the prefixes of valid usernames (see utils.ValidUsername)
+var searchQuery = regexp.MustCompile(`^[a-zA-Z0-9]{1,16}$`)
+
+// searchUsers lists the users whose username starts with the `q` query parameter (case-insensitive), to find the
+// users to start a chat with. Users who blocked the caller are not listed.
+func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	prefix := r.URL.Query().Get("q")
+	if !searchQuery.MatchString(prefix) {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid search query")
+		return
+	}
+
+	limit, offset, ok := pagination(w, r, 20, 100)
+	if !ok {
+		return
+	}
+
+	users, err := rt.db.SearchUsers(userId, prefix, limit, offset)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to search users")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to search users")
+		return
+	}
+
+	var response struct {
+		Users      []userResponse `json:"users"`
+		NextOffset *int           `json:"nextOffset,omitempty"`
+	}
+	response.Users = []userResponse{}
+	for _, u := range users {
+		response.Users = append(response.Users, newUserResponse(u))
+	}
+	// A full page means that there may be more results
+	if len(users) == limit {
+		next := offset + limit
+		response.NextOffset = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
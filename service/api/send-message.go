@@ -2,11 +2,95 @@ package api
 
 import (
 	"net/http"
+	"strconv"
 	"wasatext/service/api/reqcontext"
+	"wasatext/service/globaltime"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// sendMessage sends a message in the chat, or schedules it if a sending time is set (see scheduleMessage). The draft
+// of the user in the chat is deleted
 func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
 
+	// Parsing the chat id
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+
+	// Only members can send messages
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	content, err := readMessageContent(w, r)
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// In private chats, nobody can send messages when either user blocked the other one. The response is the same as
+	// for a missing chat, so that users can't find out who blocked them
+	if allowed, err := rt.canMessagePrivately(userId, chatId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check blocked users")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	} else if !allowed {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	if !content.SendAt.IsZero() {
+		if rt.scheduleMessage(w, ctx, userId, chatId, content) {
+			rt.clearSentDraft(ctx, userId, chatId)
+		}
+		return
+	}
+
+	mentions, err := rt.findMentions(chatId, content.Text)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to find the mentions")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to send the message")
+		return
+	}
+	messageId, err := rt.db.SendMessage(chatId, userId, content.Text, content.Photo, false, globaltime.Now(), mentions)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to send the message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to send the message")
+		return
+	}
+	rt.messageSent(chatId, messageId, userId)
+	rt.clearSentDraft(ctx, userId, chatId)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// messageSent does what follows a new message, sent by the user or by the dispatcher of scheduled messages: the
+// mentioned users are notified, and the typing state of the sender ends
+func (rt *_router) messageSent(chatId int, messageId int, senderId int) {
+	rt.notifyMentions(chatId, messageId, senderId)
+
+	if key := (typingKey{chatId: chatId, userId: senderId}); rt.typing.set(key, false) {
+		rt.publishTyping(key, false)
+	}
+}
+
+// clearSentDraft deletes the draft of a message just sent. The message is sent anyway, so a failure is only logged
+func (rt *_router) clearSentDraft(ctx reqcontext.RequestContext, userId int, chatId int) {
+	if err := rt.clearDraft(userId, chatId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to delete the draft")
+	}
 }
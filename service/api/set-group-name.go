@@ -1,12 +1,88 @@
 package api
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// maxChatNameLength is the maximum length (in characters) of a group name
+const maxChatNameLength = 30
+
+// setGroupName renames a group. Any member can rename it; a renamed system message is added to the group
 func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, chatId, ok := rt.groupOfMember(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		NewGroupName string `json:"newGroupName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	name := strings.TrimSpace(reqBody.NewGroupName)
+	if name == "" || utf8.RuneCountInString(name) > maxChatNameLength {
+		returnErrorResponse(w, http.StatusBadRequest, "The group name must be between 1 and 30 characters")
+		return
+	}
+
+	oldName, err := rt.db.GetChatName(chatId)
+	if err == nil && oldName != name {
+		err = rt.db.SetChatName(chatId, name)
+		if err == nil {
+			rt.addSystemMessage(ctx, chatId, userId, database.SystemRenamed, name)
+		}
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to rename the group")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to rename the group")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// groupOfMember returns the user and the chat of a request about a group the user is a member of. If the user is not
+// authorized, not a member, or the chat is not a group, the error response is sent
+func (rt *_router) groupOfMember(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) (int, int, bool) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return 0, 0, false
+	}
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return 0, 0, false
+	}
+
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return 0, 0, false
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return 0, 0, false
+	}
 
+	groupChat, err := rt.db.GroupChat(chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the chat")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return 0, 0, false
+	}
+	if !groupChat {
+		returnErrorResponse(w, http.StatusForbidden, "Not a group")
+		return 0, 0, false
+	}
+	return userId, chatId, true
 }
@@ -3,10 +3,30 @@ package api
 import (
 	"net/http"
 	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// setGroupPhoto replaces the photo (a GIF) of a group. Any member can change it; a photo_changed system message is
+// added to the group
 func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, chatId, ok := rt.groupOfMember(w, r, ps, ctx)
+	if !ok {
+		return
+	}
 
+	photo, err := readGif(w, r)
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := rt.db.SetChatPhoto(chatId, photo); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to set the group photo")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to set the group photo")
+		return
+	}
+	rt.addSystemMessage(ctx, chatId, userId, database.SystemPhotoChanged, "")
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// setMemberRole promotes a member of a group to admin, or demotes them. Only admins can change roles, and not their
+// own (so that a group always keeps an admin who can manage it). A role_changed system message is added to the group
+func (rt *_router) setMemberRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, chatId, ok := rt.groupOfMember(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+	memberId, err := strconv.Atoi(ps.ByName("userId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid user id")
+		return
+	}
+
+	var reqBody struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	if reqBody.Role != database.RoleAdmin && reqBody.Role != database.RoleMember {
+		returnErrorResponse(w, http.StatusBadRequest, "The role must be admin or member")
+		return
+	}
+
+	role, err := rt.db.GetChatMemberRole(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the role")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if role != database.RoleAdmin {
+		returnErrorResponse(w, http.StatusForbidden, "Only admins can change roles")
+		return
+	}
+	if memberId == userId {
+		returnErrorResponse(w, http.StatusForbidden, "You can't change your own role")
+		return
+	}
+
+	memberRole, err := rt.db.GetChatMemberRole(memberId, chatId)
+	if errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the role")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if memberRole == reqBody.Role {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := rt.db.SetChatMemberRole(memberId, chatId, reqBody.Role); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to set the role")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to set the role")
+		return
+	}
+	rt.addSystemMessage(ctx, chatId, userId, database.SystemRoleChanged, reqBody.Role, memberId)
+	w.WriteHeader(http.StatusNoContent)
+}
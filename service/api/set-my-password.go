@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/utils"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// setMyPassword sets or changes the passphrase of the user. The current passphrase is required when the account has
+// one. The API key is replaced, so that every other session is logged out: the new key is returned to the caller.
+func (rt *_router) setMyPassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// Users can change only their own passphrase
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var requestBody struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !utils.ValidPassphrase(requestBody.NewPassword) {
+		returnErrorResponse(w, http.StatusBadRequest, "Passphrase must be between 8 and 72 characters")
+		return
+	}
+
+	// Verify the current passphrase, if any
+	passwordHash, err := rt.db.GetPasswordHash(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the passphrase")
+		returnErrorResponse(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if passwordHash != "" && !checkPassphrase(passwordHash, requestBody.CurrentPassword) {
+		returnErrorResponse(w, http.StatusForbidden, "The current passphrase is wrong")
+		return
+	}
+
+	newHash, err := hashPassphrase(requestBody.NewPassword)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to hash the passphrase")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to update the passphrase")
+		return
+	}
+
+	// Revoke other sessions by replacing the API key
+	apiKey, err := generateApiKey()
+	if err != nil {
+		returnErrorResponse(w, http.StatusInternalServerError, "Error generating API key")
+		return
+	}
+
+	if err := rt.db.SetPasswordHash(userId, newHash, apiKey); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to update the passphrase")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to update the passphrase")
+		return
+	}
+
+	response := struct {
+		UserId int    `json:"userId"`
+		APIKey string `json:"apiKey"`
+	}{
+		UserId: userId,
+		APIKey: apiKey,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// setMyPrivacy sets who can see the online state and the last seen timestamp of the user: everyone, contacts (users in
+// their contact list) or nobody
+func (rt *_router) setMyPrivacy(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		LastSeen string `json:"lastSeen"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+	switch reqBody.LastSeen {
+	case database.PrivacyEveryone, database.PrivacyContacts, database.PrivacyNobody:
+	default:
+		returnErrorResponse(w, http.StatusBadRequest, "lastSeen must be one of everyone, contacts or nobody")
+		return
+	}
+
+	if err := rt.db.SetLastSeenPrivacy(userId, reqBody.LastSeen); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to update the privacy settings")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to update the privacy settings")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
 	"wasatext/service/api/reqcontext"
 	"wasatext/service/utils"
 
@@ -11,27 +10,8 @@ import (
 )
 
 func (rt *_router) setMyUsername(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
-	const bearerPrefix = "Bearer "
-
-	// Check authorization header
-	token := r.Header.Get("Authorization")
-	if len(token) <= len(bearerPrefix) || token[:len(bearerPrefix)] != bearerPrefix {
-		writeErrorResponse(w, http.StatusUnauthorized, "You are not logged in. Please log in to continue.")
-		return
-	}
-	token = token[len(bearerPrefix):]
-
-	// Extract and validate user id from url
-	userID, err := strconv.Atoi(ps.ByName("id"))
-	if err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid user ID.")
-		return
-	}
-
-	// Verify if the token matches the user's actual key
-	securityKey, err := rt.db.GetUserKey(userID)
-	if err != nil || securityKey != token {
-		writeErrorResponse(w, http.StatusUnauthorized, "Invalid session. Please log in again.")
+	userID, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
 		return
 	}
 
@@ -44,8 +24,7 @@ func (rt *_router) setMyUsername(w http.ResponseWriter, r *http.Request, ps http
 		return
 	}
 	if !utils.ValidUsername(requestBody.Username) {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "Username must be alphanumeric and between 3 and 16 characters"})
+		returnErrorResponse(w, http.StatusBadRequest, "Username must be alphanumeric and between 3 and 16 characters")
 		return
 	}
 
@@ -70,7 +49,5 @@ func (rt *_router) setMyUsername(w http.ResponseWriter, r *http.Request, ps http
 }
 
 func writeErrorResponse(w http.ResponseWriter, status int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+	returnErrorResponse(w, status, message)
 }
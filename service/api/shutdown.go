@@ -1,6 +1,31 @@
 package api
 
-// Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines.
+import "sync/atomic"
+
+// Drain makes the readiness probe report the server as unavailable, so that no new traffic is routed here while
+// requests are still served. It's called before shutting down the HTTP server, leaving load balancers the time to
+// notice.
+func (rt *_router) Drain() {
+	atomic.StoreInt32(&rt.shuttingDown, 1)
+}
+
+// CloseStreams ends the real-time event streams: clients reconnect, to another server. It's called when the HTTP
+// server starts shutting down, which would otherwise wait for the streams to time out.
+func (rt *_router) CloseStreams() {
+	if atomic.CompareAndSwapInt32(&rt.streamsClosed, 0, 1) {
+		close(rt.streamsDone)
+	}
+}
+
+// Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines. It's
+// called after the HTTP server drained the outstanding requests.
 func (rt *_router) Close() error {
+	if !atomic.CompareAndSwapInt32(&rt.closed, 0, 1) {
+		return nil
+	}
+	rt.Drain()
+	rt.CloseStreams()
+	close(rt.done)
+	rt.background.Wait()
 	return nil
 }
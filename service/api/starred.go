@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"wasatext/service/api/reqcontext"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// getStarredMessages lists the messages starred by the user in every chat, most recently starred first, with the
+// chat they belong to
+func (rt *_router) getStarredMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	limit, offset, ok := pagination(w, r, 20, 100)
+	if !ok {
+		return
+	}
+
+	starred, err := rt.db.GetStarredMessages(userId, limit, offset)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the starred messages")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the starred messages")
+		return
+	}
+	nicknames, err := rt.db.GetContactNicknames(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the contacts")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the starred messages")
+		return
+	}
+
+	type starredMessage struct {
+		messageResponse
+		ChatId    int       `json:"chatId"`
+		ChatName  string    `json:"chatName"`
+		GroupChat bool      `json:"groupChat"`
+		StarredAt time.Time `json:"starredAt"`
+	}
+	var response struct {
+		Starred    []starredMessage `json:"starred"`
+		NextOffset *int             `json:"nextOffset,omitempty"`
+	}
+	response.Starred = []starredMessage{}
+
+	// Messages of the same chat share its name
+	names := make(map[int]string)
+	for _, s := range starred {
+		name, ok := names[s.ChatId]
+		if !ok {
+			members, err := rt.db.GetChatMemberProfiles(s.ChatId)
+			if err != nil {
+				ctx.Logger.WithError(err).Error("Failed to retrieve the chat members")
+				returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the starred messages")
+				return
+			}
+			name = chatName(userId, s.ChatName, s.GroupChat, members, nicknames)
+			names[s.ChatId] = name
+		}
+
+		response.Starred = append(response.Starred, starredMessage{
+			messageResponse: newMessageResponse(s.ChatMessage, userId),
+			ChatId:          s.ChatId,
+			ChatName:        name,
+			GroupChat:       s.GroupChat,
+			StarredAt:       s.StarredAt,
+		})
+	}
+	// A full page means that there may be more results
+	if len(starred) == limit {
+		next := offset + limit
+		response.NextOffset = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// starMessage stars a message of a chat the user is a member of. System messages can't be starred
+func (rt *_router) starMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.visibleMessage(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+	if message.System != nil {
+		returnErrorResponse(w, http.StatusForbidden, "System messages can't be starred")
+		return
+	}
+
+	if err := rt.db.StarMessage(userId, message.ChatId, message.ID); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to star the message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to star the message")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unstarMessage removes the star from a message
+func (rt *_router) unstarMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.visibleMessage(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	if err := rt.db.UnstarMessage(userId, message.ID); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to unstar the message")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to unstar the message")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+	"wasatext/service/globaltime"
+)
+
+// systemResponse is the group event of a system message. The actor is the sender of the message
+type systemResponse struct {
+	Type     string `json:"type"`
+	Subjects []int  `json:"subjects"`
+	Name     string `json:"name,omitempty"` // renamed
+	Role     string `json:"role,omitempty"` // role_changed
+}
+
+func newSystemResponse(e database.SystemEvent) systemResponse {
+	response := systemResponse{Type: e.Type, Subjects: e.Subjects}
+	switch e.Type {
+	case database.SystemRenamed:
+		response.Name = e.Value
+	case database.SystemRoleChanged:
+		response.Role = e.Value
+	}
+	return response
+}
+
+// addSystemMessage records a group event in the chat history. The event already happened, so a failure is only logged
+func (rt *_router) addSystemMessage(ctx reqcontext.RequestContext, chatId int, actorId int, systemType string, value string, subjects ...int) {
+	_, err := rt.db.AddSystemMessage(chatId, actorId, systemType, value, subjects, globaltime.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("system-type", systemType).Error("Failed to add the system message")
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"wasatext/service/globaltime"
+	"wasatext/service/totp"
+)
+
+const (
+	// totpIssuer is the name shown by authenticator apps
+	totpIssuer = "WaSAText"
+
+	// recoveryCodeCount is the number of recovery codes generated when TOTP is enabled
+	recoveryCodeCount = 10
+)
+
+// generateRecoveryCodes returns new recovery codes (formatted as "xxxx-xxxx") and their hashes, to be stored
+func generateRecoveryCodes() ([]string, []string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	var codes, hashes []string
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		var code []byte
+		for j := 0; j < 8; j++ {
+			index, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+			if err != nil {
+				return nil, nil, err
+			}
+			code = append(code, alphabet[index.Int64()])
+		}
+		formatted := string(code[:4]) + "-" + string(code[4:])
+		codes = append(codes, formatted)
+		hashes = append(hashes, hashRecoveryCode(formatted))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code. Codes are random, so a plain SHA-256 is enough
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifySecondFactor checks a TOTP code or, if it's not a valid TOTP code, a recovery code (which is consumed)
+func (rt *_router) verifySecondFactor(userId int, code string) (bool, error) {
+	secret, enabled, lastStep, err := rt.db.GetTotp(userId)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		return false, nil
+	}
+
+	// The step is consumed with a conditional update: of two requests with the same code, only one is accepted
+	if step, ok := totp.Validate(secret, code, globaltime.Now(), lastStep); ok {
+		return rt.db.UseTotpStep(userId, step)
+	}
+
+	return rt.db.UseRecoveryCode(userId, hashRecoveryCode(code))
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/globaltime"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// typingTTL is how long a typing state lasts: clients send it again every few seconds while the user is typing
+	typingTTL = 5 * time.Second
+
+	// typingJanitorInterval is the interval between checks for expired typing states
+	typingJanitorInterval = time.Second
+)
+
+// typingKey identifies a user typing in a chat
+type typingKey struct {
+	chatId int
+	userId int
+}
+
+// typingStates is an in-memory store of the users currently typing, with the expiration of their state. Losing them on
+// restart is fine, as they last a few seconds anyway.
+type typingStates struct {
+	mu      sync.Mutex
+	expires map[typingKey]time.Time
+}
+
+func newTypingStates() *typingStates {
+	return &typingStates{expires: make(map[typingKey]time.Time)}
+}
+
+// set starts (or refreshes) the typing state of the user in the chat, or stops it. It reports whether the state
+// changed, so that only changes are broadcast.
+func (ts *typingStates) set(key typingKey, typing bool) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	_, wasTyping := ts.expires[key]
+	if typing {
+		ts.expires[key] = globaltime.Now().Add(typingTTL)
+	} else {
+		delete(ts.expires, key)
+	}
+	return wasTyping != typing
+}
+
+// expired removes and returns the expired typing states
+func (ts *typingStates) expired() []typingKey {
+	now := globaltime.Now()
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var keys []typingKey
+	for key, expires := range ts.expires {
+		if !now.Before(expires) {
+			keys = append(keys, key)
+			delete(ts.expires, key)
+		}
+	}
+	return keys
+}
+
+// typingEvent is the payload of "typing" real-time events
+type typingEvent struct {
+	ChatId int  `json:"chatId"`
+	UserId int  `json:"userId"`
+	Typing bool `json:"typing"`
+}
+
+// setTyping records that the user is typing in the chat (or stopped, with `{"typing": false}`). The other members
+// are notified through the real-time channel when the user starts and stops typing; the state expires after a few
+// seconds if not sent again.
+func (rt *_router) setTyping(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeUser(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	chatId, err := strconv.Atoi(ps.ByName("chatId"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid conversation id")
+		return
+	}
+
+	// The body is optional
+	reqBody := struct {
+		Typing bool `json:"typing"`
+	}{Typing: true}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil && !errors.Is(err, io.EOF) {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+
+	isMember, err := rt.db.ChatMember(userId, chatId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check chat membership")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if !isMember {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	// Users who can't send messages in the chat can't type either (see sendMessage)
+	if allowed, err := rt.canMessagePrivately(userId, chatId); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to check blocked users")
+		returnErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	} else if !allowed {
+		returnErrorResponse(w, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	key := typingKey{chatId: chatId, userId: userId}
+	if rt.typing.set(key, reqBody.Typing) {
+		rt.publishTyping(key, reqBody.Typing)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// publishTyping sends the typing state to the other members of the chat
+func (rt *_router) publishTyping(key typingKey, typing bool) {
+	members, err := rt.db.GetChatMembers(key.chatId)
+	if err != nil {
+		rt.baseLogger.WithError(err).WithField("chat-id", key.chatId).Error("can't retrieve the chat members")
+		return
+	}
+
+	var recipients []int
+	for _, member := range members {
+		if member != key.userId {
+			recipients = append(recipients, member)
+		}
+	}
+	rt.hub.Publish("typing", typingEvent{ChatId: key.chatId, UserId: key.userId, Typing: typing}, recipients...)
+}
+
+// typingJanitor periodically expires the typing states, notifying the chat members, until Close is called
+func (rt *_router) typingJanitor() {
+	defer rt.background.Done()
+
+	ticker := time.NewTicker(typingJanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rt.done:
+			return
+		case <-ticker.C:
+			for _, key := range rt.typing.expired() {
+				rt.publishTyping(key, false)
+			}
+		}
+	}
+}
@@ -7,6 +7,17 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
+// uncommentMessage removes the comment of the user from a message. Removing a missing comment is not an error
 func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, message, ok := rt.visibleMessage(w, r, ps, ctx)
+	if !ok {
+		return
+	}
 
+	if err := rt.db.RemoveComment(userId, message.ID); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to remove the comment")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to remove the comment")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
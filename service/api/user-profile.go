@@ -0,0 +1,163 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"wasatext/service/api/reqcontext"
+	"wasatext/service/database"
+	"wasatext/service/utils"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// userResponse is a user as listed in responses (chat members, search results, ...). Clients show the nickname given
+// by the user to the contact, falling back to the display name and then to the username
+type userResponse struct {
+	UserId      int    `json:"userId"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName,omitempty"`
+	Nickname    string `json:"nickname,omitempty"`
+	Status      string `json:"status,omitempty"`
+	HasPhoto    bool   `json:"hasPhoto"`
+}
+
+func newUserResponse(u database.UserSummary) userResponse {
+	return userResponse{
+		UserId:      u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		Status:      u.Status,
+		HasPhoto:    u.HasPhoto,
+	}
+}
+
+// newMemberResponses returns the users, with the nicknames given to them by the viewer
+func newMemberResponses(users []database.UserSummary, nicknames map[int]string) []userResponse {
+	response := []userResponse{}
+	for _, u := range users {
+		member := newUserResponse(u)
+		member.Nickname = nicknames[u.ID]
+		response = append(response, member)
+	}
+	return response
+}
+
+// chatName returns the name of a chat as seen by the viewer. Group chats have their own name, while private chats are
+// named after the other member: the nickname given by the viewer, or their display name, or their username.
+func chatName(viewerId int, name string, groupChat bool, members []database.UserSummary, nicknames map[int]string) string {
+	if groupChat {
+		return name
+	}
+	for _, member := range members {
+		if member.ID == viewerId {
+			continue
+		}
+		switch {
+		case nicknames[member.ID] != "":
+			return nicknames[member.ID]
+		case member.DisplayName != "":
+			return member.DisplayName
+		default:
+			return member.Username
+		}
+	}
+	// The other member deleted their account. Chats created before names were rendered still have a stored name
+	if name != "" {
+		return name
+	}
+	return database.DeletedUsername
+}
+
+// getUserProfile returns the profile of a user: username, display name, bio and status line
+func (rt *_router) getUserProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if _, ok := rt.authorizeUser(w, r, ctx); !ok {
+		return
+	}
+
+	userId, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := rt.db.GetUser(userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		returnErrorResponse(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the user")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the profile")
+		return
+	}
+
+	rt.writeProfile(w, user)
+}
+
+// updateMyProfile updates the profile of the user. Only the fields in the request are changed; empty strings clear
+// them
+func (rt *_router) updateMyProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, ok := rt.authorizeSelf(w, r, ps, ctx)
+	if !ok {
+		return
+	}
+
+	var reqBody struct {
+		DisplayName *string `json:"displayName"`
+		Bio         *string `json:"bio"`
+		Status      *string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		returnErrorResponse(w, http.StatusBadRequest, "Invalid JSON provided")
+		return
+	}
+
+	for _, field := range []struct {
+		value     *string
+		maxLength int
+		multiline bool
+		message   string
+	}{
+		{reqBody.DisplayName, database.MaxDisplayNameLength, false, "The display name must be at most 64 characters long, on one line"},
+		{reqBody.Bio, database.MaxBioLength, true, "The bio must be at most 500 characters long"},
+		{reqBody.Status, database.MaxStatusLength, false, "The status must be at most 140 characters long, on one line"},
+	} {
+		if field.value == nil {
+			continue
+		}
+		*field.value = strings.TrimSpace(*field.value)
+		if !utils.ValidProfileText(*field.value, field.maxLength, field.multiline) {
+			returnErrorResponse(w, http.StatusBadRequest, field.message)
+			return
+		}
+	}
+
+	if err := rt.db.UpdateProfile(userId, reqBody.DisplayName, reqBody.Bio, reqBody.Status); err != nil {
+		ctx.Logger.WithError(err).Error("Failed to update the profile")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to update the profile")
+		return
+	}
+
+	user, err := rt.db.GetUser(userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("Failed to retrieve the user")
+		returnErrorResponse(w, http.StatusInternalServerError, "Failed to retrieve the profile")
+		return
+	}
+	rt.writeProfile(w, user)
+}
+
+// writeProfile sends the profile of the user to the client
+func (rt *_router) writeProfile(w http.ResponseWriter, user database.UserInfo) {
+	response := struct {
+		userResponse
+		Bio string `json:"bio,omitempty"`
+	}{newUserResponse(user.UserSummary), user.Bio}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -6,14 +6,59 @@ import (
 	"fmt"
 	"image/gif"
 	"time"
+	"wasatext/service/globaltime"
 )
 
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
 	UserExists(username string) (bool, error)
-	CreateUser(username string, securityKey string) (int, error)
+	CreateUser(username string, securityKey string, passwordHash string) (int, error)
 	GetUserKey(userId int) (string, error)
+	GetPasswordHash(userId int) (string, error)
+	SetPasswordHash(userId int, passwordHash string, securityKey string) error
+	GetTotp(userId int) (string, bool, int64, error)
+	SetTotpSecret(userId int, secret string) error
+	EnableTotp(userId int, step int64, recoveryCodeHashes []string) error
+	DisableTotp(userId int) error
+	UseTotpStep(userId int, step int64) (bool, error)
+	UseRecoveryCode(userId int, codeHash string) (bool, error)
+	GetUserIdByOidcSubject(issuer string, subject string) (int, error)
+	CreateOidcUser(username string, securityKey string, issuer string, subject string) (int, error)
+	HasOidcIdentity(userId int) (bool, error)
+	DeleteUser(userId int, securityKey string) error
+	GetUserPhoto(userId int) ([]byte, error)
+	GetSentMessages(userId int) ([]SentMessage, error)
+	GetUserComments(userId int) ([]UserComment, error)
 	GetUserIdByKey(securityKey string) (int, error)
+	SearchUsers(callerId int, prefix string, limit int, offset int) ([]UserSummary, error)
+	GetUser(userId int) (UserInfo, error)
+	UpdateProfile(userId int, displayName *string, bio *string, status *string) error
+	GetChatMemberProfiles(chatId int) ([]UserSummary, error)
+	GetConversations(userId int, archived bool) ([]Conversation, error)
+	GetChatMessageList(chatId int) ([]ChatMessage, error)
+	GetChatMessagePage(chatId int, limit int, offset int) ([]ChatMessage, error)
+	GetChatMessage(chatId int, messageId int) (ChatMessage, error)
+	MarkChatSeen(userId int, chatId int, messageIds []int) error
+	GetChatSettings(userId int, chatId int) (ChatSettings, error)
+	UpdateChatSettings(userId int, chatId int, mutedUntil *time.Time, pinned *bool, archived *bool, markedUnread *bool) (bool, error)
+	GetDraft(userId int, chatId int) (Draft, error)
+	SetDraft(userId int, chatId int, draft Draft) error
+	DeleteDraft(userId int, chatId int) (bool, error)
+	GetPinnedChats(userId int) ([]int, error)
+	ReorderPinnedChats(userId int, chatIds []int) error
+	SetLastSeen(userId int, lastSeen time.Time) error
+	SetLastSeenPrivacy(userId int, privacy string) error
+	GetSharedChatUsers(userId int) ([]int, error)
+	SetContact(ownerId int, contactId int, nickname *string, favourite *bool) error
+	RemoveContact(ownerId int, contactId int) error
+	GetContacts(ownerId int) ([]Contact, error)
+	GetContactNicknames(ownerId int) (map[int]string, error)
+	IsContact(ownerId int, contactId int) (bool, error)
+	BlockUser(blockerId int, blockedId int) error
+	UnblockUser(blockerId int, blockedId int) error
+	GetBlockedUsers(blockerId int) ([]BlockedUser, error)
+	IsBlocked(blockerId int, blockedId int) (bool, error)
+	BlockedBetween(userA int, userB int) (bool, error)
 	GetUsername(userId int) (string, error)
 	GetUserIdByUsername(username string) (int, error)
 	UpdateUsername(userId int, newUsername string) error
@@ -21,16 +66,41 @@ type AppDatabase interface {
 	NewChat(chatName string, groupChat bool) (int, error)
 	AddChatMember(userId int, chatId int) error
 	ChatMember(userId int, chatId int) (bool, error)
+	GetChatMemberRole(userId int, chatId int) (string, error)
+	SetChatMemberRole(userId int, chatId int, role string) error
 	GroupChat(chatId int) (bool, error)
 	SetChatName(chatId int, newName string) error
 	GetChatName(chatId int) (string, error)
+	SetChatPhoto(chatId int, photo []byte) error
+	GetMessageTimer(chatId int) (time.Duration, error)
+	SetMessageTimer(chatId int, timer time.Duration) error
+	DeleteExpiredMessages(now time.Time, limit int) (int, error)
 	GetChatMembers(chatId int) ([]int, error)
 	GetUserCount() (int, error)
 	RemoveChatMember(userId int, chatId int) error
 	AddComment(textContent string, senderId int, messageId int) error
 	RemoveComment(senderId int, messageId int) error
-	SendMessage(chatId int, senderId int, textContent string, forwarded bool, timestamp time.Time) error
+	SendMessage(chatId int, senderId int, textContent string, photo []byte, forwarded bool, timestamp time.Time, mentions []Mention) (int, error)
 	DeleteMessage(messageId int) error
+	AddSystemMessage(chatId int, actorId int, systemType string, value string, subjects []int, timestamp time.Time) (int, error)
+	CreatePoll(chatId int, senderId int, question string, options []string, multipleChoice bool, anonymous bool, closesAt time.Time, timestamp time.Time, mentions []Mention) (int, error)
+	VotePoll(messageId int, userId int, optionIds []int, now time.Time) (bool, error)
+	RetractPollVote(messageId int, userId int, now time.Time) (bool, error)
+	ClosePoll(messageId int, closedAt time.Time) (bool, error)
+	ScheduleMessage(chatId int, senderId int, textContent string, photo []byte, sendAt time.Time) (int, error)
+	GetScheduledMessages(senderId int, chatId int) ([]ScheduledMessage, error)
+	GetScheduledMessage(senderId int, chatId int, scheduledId int) (ScheduledMessage, error)
+	UpdateScheduledMessage(scheduledId int, textContent *string, sendAt *time.Time) error
+	CancelScheduledMessage(scheduledId int) error
+	GetDueScheduledMessages(now time.Time, limit int, offset int) ([]ScheduledMessage, error)
+	DispatchScheduledMessage(scheduledId int, textContent string, timestamp time.Time, mentions []Mention) (int, bool, error)
+	PinMessage(chatId int, messageId int, userId int, max int) (bool, error)
+	UnpinMessage(chatId int, messageId int) (bool, error)
+	GetPinnedMessages(chatId int) ([]PinnedMessage, error)
+	StarMessage(userId int, chatId int, messageId int) error
+	UnstarMessage(userId int, messageId int) error
+	GetStarredMessages(userId int, limit int, offset int) ([]StarredMessage, error)
+	GetMentionedUsers(messageId int) ([]int, error)
 	ViewMessage(userId int, messageId int) error
 	ReceiveMessage(userId int, messageId int) error
 	GetChatMessages(chatId int) ([]int, error)
@@ -38,6 +108,8 @@ type AppDatabase interface {
 	SeenMessage(messageId int) ([]int, error)
 	GetMessage(messageId int) (int, string, bool, time.Time, error)
 	Ping() error
+	GetSchemaVersion() (int, error)
+	CheckWritable() error
 }
 
 type User struct {
@@ -63,8 +135,77 @@ type Message struct {
 	SenderId    uint64
 }
 
+// Roles of the chat members. Admins manage the group; in private chats both members are plain members
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// DeletedUsername is shown in place of the username of deleted accounts
+const DeletedUsername = "Deleted user"
+
+// SentMessage is a message sent by a user, as exported by GetSentMessages
+type SentMessage struct {
+	ID          int
+	ChatId      int
+	TextContent string
+	Photo       []byte
+	Forwarded   bool
+	Timestamp   time.Time
+}
+
+// UserComment is a comment (reaction) left by a user on a message
+type UserComment struct {
+	MessageId int
+	ChatId    int
+	Comment   string
+}
+
+// UserSummary is a user as listed in the user directory, in chats and in block lists
+type UserSummary struct {
+	ID          int
+	Username    string
+	DisplayName string
+	Status      string
+	HasPhoto    bool
+}
+
+// Limits of the profile fields (in characters)
+const (
+	MaxDisplayNameLength = 64
+	MaxBioLength         = 500
+	MaxStatusLength      = 140
+)
+
+// Visibility of the last seen timestamp (users.last_seen_privacy)
+const (
+	PrivacyEveryone = "everyone"
+	PrivacyContacts = "contacts"
+	PrivacyNobody   = "nobody"
+)
+
+// MaxNicknameLength is the maximum length (in characters) of a contact nickname
+const MaxNicknameLength = 64
+
+// Contact is an entry of a user's contact list
+type Contact struct {
+	UserSummary
+	Nickname  string
+	Favourite bool
+	ChatId    int // the private chat with the contact, 0 if none
+}
+
+// UserInfo is the public information about a user
+type UserInfo struct {
+	UserSummary
+	Bio             string
+	Deleted         bool
+	LastSeen        time.Time // zero if never seen
+	LastSeenPrivacy string
+}
+
 type appdbimpl struct {
-	c *sql.DB
+	c timedDB
 }
 
 func New(db *sql.DB) (AppDatabase, error) {
@@ -156,11 +297,31 @@ func New(db *sql.DB) (AppDatabase, error) {
 		}
 	}
 
+	err = migrate(db)
+	if err != nil {
+		return nil, fmt.Errorf("error migrating database structure: %w", err)
+	}
+
 	return &appdbimpl{
-		c: db,
+		c: timedDB{db},
 	}, nil
 }
 
 func (db *appdbimpl) Ping() error {
 	return db.c.Ping()
 }
+
+// GetSchemaVersion returns the schema version stored in the database
+func (db *appdbimpl) GetSchemaVersion() (int, error) {
+	var version int
+	err := db.c.method("GetSchemaVersion").QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// CheckWritable verifies that the database accepts writes. Media (GIF images) are stored in the database too, so this
+// also checks the media storage.
+func (db *appdbimpl) CheckWritable() error {
+	_, err := db.c.method("CheckWritable").Exec(`
+		INSERT OR REPLACE INTO healthcheck (id, checked_at) VALUES (1, ?)`, globaltime.Now())
+	return err
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"wasatext/service/globaltime"
+)
+
+// Deleting an account. The row is kept (anonymised) so that messages sent by the user are still readable in group
+// histories, shown as sent by DeletedUsername. Credentials, photo, memberships, blocks, contacts (in both directions)
+// and linked identities are removed; the security key is replaced, so every session is revoked. The groups of the
+// user get a member_left system message, as if they left.
+func (db *appdbimpl) DeleteUser(userId int, securityKey string) error {
+	tx, err := db.c.method("DeleteUser").Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// Groups whose last admin is the user get a new admin, once the memberships are removed
+	now := globaltime.Now()
+	groupIds, err := userGroups(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	// The placeholder username is not a valid username, so nobody can register it
+	_, err = tx.Exec(`
+		UPDATE users SET username = ?, security_key = ?, gif_photo = NULL, password_hash = NULL,
+			totp_secret = NULL, totp_enabled = false, totp_last_step = 0, display_name = NULL, bio = NULL,
+			status = NULL, deleted_at = ?
+		WHERE id = ? AND deleted_at IS NULL`,
+		fmt.Sprintf("~deleted-%d", userId), securityKey, now, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM oidc_identities WHERE user_id = ?`,
+		`DELETE FROM chat_members WHERE user_id = ?`,
+		`DELETE FROM contacts WHERE owner_id = ?`,
+		`DELETE FROM starred_messages WHERE user_id = ?`,
+		`DELETE FROM scheduled_messages WHERE sender_id = ?`,
+		`DELETE FROM message_status WHERE user_id = ?`,
+		`DELETE FROM poll_votes WHERE user_id = ?`,
+	} {
+		_, err = tx.Exec(stmt, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Blocks are removed in both directions: the ones by the user and the ones against the user
+	_, err = tx.Exec(`DELETE FROM blocked_users WHERE blocker_id = ? OR blocked_id = ?`, userId, userId)
+	if err != nil {
+		return err
+	}
+
+	// The user is removed from the contact lists of the others too
+	_, err = tx.Exec(`DELETE FROM contacts WHERE contact_id = ?`, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, groupId := range groupIds {
+		err = keepGroupAdmin(tx, groupId)
+		if err != nil {
+			return err
+		}
+		_, err = insertSystemMessage(tx, groupId, userId, SystemMemberLeft, "", nil, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	messagesSent.Add(float64(len(groupIds)), "system")
+	return nil
+}
+
+// userGroups returns the group chats the user is a member of
+func userGroups(tx *timedTx, userId int) ([]int, error) {
+	rows, err := tx.Query(`
+		SELECT cm.chat_id FROM chat_members cm JOIN chats c ON c.id = cm.chat_id
+		WHERE cm.user_id = ? AND c.group_chat`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groupIds []int
+	for rows.Next() {
+		var groupId int
+		if err := rows.Scan(&groupId); err != nil {
+			return nil, err
+		}
+		groupIds = append(groupIds, groupId)
+	}
+	return groupIds, rows.Err()
+}
+
+// Retrieving the profile photo of the user (nil if not set)
+func (db *appdbimpl) GetUserPhoto(userId int) ([]byte, error) {
+	var photo []byte
+	err := db.c.method("GetUserPhoto").QueryRow(`SELECT gif_photo FROM users WHERE id = ?`, userId).Scan(&photo)
+	if err != nil {
+		return nil, err
+	}
+	return photo, nil
+}
+
+// Retrieving every message sent by the user, in every chat (system messages are not written by the user)
+func (db *appdbimpl) GetSentMessages(userId int) ([]SentMessage, error) {
+	rows, err := db.c.method("GetSentMessages").Query(`
+		SELECT id, chat_id, COALESCE(raw_text, text_message), gif_photo, forwarded, timestamp FROM messages
+		WHERE sender_id = ? AND system_type IS NULL AND `+notExpired("messages")+` ORDER BY timestamp`, userId, expiryNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []SentMessage
+	for rows.Next() {
+		var m SentMessage
+		var text sql.NullString
+		var forwarded sql.NullBool
+		if err := rows.Scan(&m.ID, &m.ChatId, &text, &m.Photo, &forwarded, &m.Timestamp); err != nil {
+			return nil, err
+		}
+		m.TextContent = text.String
+		m.Forwarded = forwarded.Bool
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+// Retrieving every comment left by the user
+func (db *appdbimpl) GetUserComments(userId int) ([]UserComment, error) {
+	rows, err := db.c.method("GetUserComments").Query(`
+		SELECT s.message_id, m.chat_id, s.comment FROM message_status s
+		JOIN messages m ON m.id = s.message_id
+		WHERE s.user_id = ? AND s.comment IS NOT NULL AND s.comment != '' AND `+notExpired("m"), userId, expiryNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []UserComment
+	for rows.Next() {
+		var c UserComment
+		if err := rows.Scan(&c.MessageId, &c.ChatId, &c.Comment); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+
+	return comments, rows.Err()
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// BlockedUser is an entry of a user's block list
+type BlockedUser struct {
+	UserSummary
+	BlockedAt time.Time
+}
+
+// Blocking a user. Blocking a user again is not an error
+func (db *appdbimpl) BlockUser(blockerId int, blockedId int) error {
+	_, err := db.c.method("BlockUser").Exec(`
+		INSERT OR IGNORE INTO blocked_users (blocker_id, blocked_id, created_at) VALUES (?, ?, ?)`,
+		blockerId, blockedId, globaltime.Now())
+	return err
+}
+
+// Unblocking a user. Unblocking a user who is not blocked is not an error
+func (db *appdbimpl) UnblockUser(blockerId int, blockedId int) error {
+	_, err := db.c.method("UnblockUser").Exec(`
+		DELETE FROM blocked_users WHERE blocker_id = ? AND blocked_id = ?`, blockerId, blockedId)
+	return err
+}
+
+// Getting the block list of a user, most recent first
+func (db *appdbimpl) GetBlockedUsers(blockerId int) ([]BlockedUser, error) {
+	rows, err := db.c.method("GetBlockedUsers").Query(`
+		SELECT `+userSummaryColumns+`, b.created_at
+		FROM blocked_users b JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = ?
+		ORDER BY b.created_at DESC, u.id`, DeletedUsername, blockerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []BlockedUser{}
+	for rows.Next() {
+		var u BlockedUser
+		if err := rows.Scan(append(u.scanTargets(), &u.BlockedAt)...); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// Checking if blockerId blocked blockedId
+func (db *appdbimpl) IsBlocked(blockerId int, blockedId int) (bool, error) {
+	var blocked bool
+	err := db.c.method("IsBlocked").QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM blocked_users WHERE blocker_id = ? AND blocked_id = ?)`,
+		blockerId, blockedId).Scan(&blocked)
+	return blocked, err
+}
+
+// Checking if either user blocked the other one
+func (db *appdbimpl) BlockedBetween(userA int, userB int) (bool, error) {
+	var blocked bool
+	err := db.c.method("BlockedBetween").QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM blocked_users
+			WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?))`,
+		userA, userB, userB, userA).Scan(&blocked)
+	return blocked, err
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MaxPinnedChats is the maximum number of conversations a user can pin
+const MaxPinnedChats = 5
+
+// MutedForever is the muted-until timestamp of chats muted without an end
+var MutedForever = time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)
+
+// ChatSettings are the settings of a chat for one of its members
+type ChatSettings struct {
+	MutedUntil   time.Time // zero if the chat has never been muted
+	Pinned       bool
+	Archived     bool
+	MarkedUnread bool
+}
+
+// Muted reports whether the chat is muted at the given time
+func (s ChatSettings) Muted(now time.Time) bool {
+	return now.Before(s.MutedUntil)
+}
+
+// Getting the settings of a chat for a member. It returns sql.ErrNoRows if the user is not a member of the chat
+func (db *appdbimpl) GetChatSettings(userId int, chatId int) (ChatSettings, error) {
+	var s ChatSettings
+	var mutedUntil sql.NullTime
+	err := db.c.method("GetChatSettings").QueryRow(`
+		SELECT muted_until, pin_order IS NOT NULL, archived, marked_unread
+		FROM chat_members WHERE user_id = ? AND chat_id = ?`, userId, chatId).
+		Scan(&mutedUntil, &s.Pinned, &s.Archived, &s.MarkedUnread)
+	s.MutedUntil = mutedUntil.Time
+	return s, err
+}
+
+// Updating the settings of a chat for a member. Nil fields are left unchanged; a zero mutedUntil unmutes the chat.
+// Newly pinned chats are placed before the other pinned chats: it returns false, changing nothing, if the user has
+// already MaxPinnedChats pinned chats
+func (db *appdbimpl) UpdateChatSettings(userId int, chatId int, mutedUntil *time.Time, pinned *bool, archived *bool, markedUnread *bool) (bool, error) {
+	var mutedValue, pinnedValue, archivedValue, unreadValue interface{}
+	if mutedUntil != nil {
+		mutedValue = sql.NullTime{Time: *mutedUntil, Valid: !mutedUntil.IsZero()}
+	}
+	if pinned != nil {
+		pinnedValue = *pinned
+	}
+	if archived != nil {
+		archivedValue = *archived
+	}
+	if markedUnread != nil {
+		unreadValue = *markedUnread
+	}
+
+	tx, err := db.c.method("UpdateChatSettings").Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// The limit is checked in the transaction, so that concurrent requests can't pin more chats
+	if pinned != nil && *pinned {
+		var full bool
+		err = tx.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM chat_members WHERE user_id = ?1 AND chat_id = ?2 AND pin_order IS NULL)
+				AND (SELECT COUNT(*) FROM chat_members WHERE user_id = ?1 AND pin_order IS NOT NULL) >= ?3`,
+			userId, chatId, MaxPinnedChats).Scan(&full)
+		if err != nil {
+			return false, err
+		}
+		if full {
+			err = tx.Rollback()
+			return false, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE chat_members SET
+			muted_until = CASE WHEN ?1 THEN ?2 ELSE muted_until END,
+			pin_order = CASE
+				WHEN ?3 IS NULL THEN pin_order
+				WHEN NOT ?3 THEN NULL
+				WHEN pin_order IS NOT NULL THEN pin_order
+				ELSE (SELECT COALESCE(MAX(pin_order), 0) + 1 FROM chat_members WHERE user_id = ?6)
+			END,
+			archived = COALESCE(?4, archived),
+			marked_unread = COALESCE(?5, marked_unread)
+		WHERE user_id = ?6 AND chat_id = ?7`,
+		mutedUntil != nil, mutedValue, pinnedValue, archivedValue, unreadValue, userId, chatId)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	return err == nil, err
+}
+
+// Getting the pinned chats of a user, in order
+func (db *appdbimpl) GetPinnedChats(userId int) ([]int, error) {
+	rows, err := db.c.method("GetPinnedChats").Query(`
+		SELECT chat_id FROM chat_members WHERE user_id = ? AND pin_order IS NOT NULL ORDER BY pin_order DESC`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := []int{}
+	for rows.Next() {
+		var chatId int
+		if err := rows.Scan(&chatId); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chatId)
+	}
+	return chats, rows.Err()
+}
+
+// Reordering the pinned chats of a user. chatIds must be the pinned chats, in the new order
+func (db *appdbimpl) ReorderPinnedChats(userId int, chatIds []int) error {
+	tx, err := db.c.method("ReorderPinnedChats").Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for i, chatId := range chatIds {
+		_, err = tx.Exec(`UPDATE chat_members SET pin_order = ? WHERE user_id = ? AND chat_id = ? AND pin_order IS NOT NULL`,
+			len(chatIds)-i, userId, chatId)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	return err
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"wasatext/service/globaltime"
+)
+
+// Adding a contact, or updating it if already in the contact list. Nil fields are left unchanged (or set to their
+// defaults for new contacts); an empty nickname clears it
+func (db *appdbimpl) SetContact(ownerId int, contactId int, nickname *string, favourite *bool) error {
+	var nicknameValue, favouriteValue interface{}
+	if nickname != nil {
+		nicknameValue = sql.NullString{String: *nickname, Valid: *nickname != ""}
+	}
+	if favourite != nil {
+		favouriteValue = *favourite
+	}
+
+	_, err := db.c.method("SetContact").Exec(`
+		INSERT INTO contacts (owner_id, contact_id, nickname, favourite, created_at)
+		VALUES (?, ?, ?, COALESCE(?, false), ?)
+		ON CONFLICT (owner_id, contact_id) DO UPDATE SET
+			nickname = CASE WHEN ? THEN excluded.nickname ELSE nickname END,
+			favourite = CASE WHEN ? THEN excluded.favourite ELSE favourite END`,
+		ownerId, contactId, nicknameValue, favouriteValue, globaltime.Now(), nickname != nil, favourite != nil)
+	return err
+}
+
+// Removing a contact. Removing a user who is not a contact is not an error
+func (db *appdbimpl) RemoveContact(ownerId int, contactId int) error {
+	_, err := db.c.method("RemoveContact").Exec(`
+		DELETE FROM contacts WHERE owner_id = ? AND contact_id = ?`, ownerId, contactId)
+	return err
+}
+
+// Getting the contact list of a user, favourites first, then by name, with the private chat with each contact
+func (db *appdbimpl) GetContacts(ownerId int) ([]Contact, error) {
+	rows, err := db.c.method("GetContacts").Query(`
+		SELECT `+userSummaryColumns+`, COALESCE(ct.nickname, ''), ct.favourite,
+			COALESCE((SELECT c.id FROM chats c
+				JOIN chat_members a ON a.chat_id = c.id AND a.user_id = ct.owner_id
+				JOIN chat_members b ON b.chat_id = c.id AND b.user_id = ct.contact_id
+				WHERE NOT c.group_chat ORDER BY c.id LIMIT 1), 0)
+		FROM contacts ct JOIN users u ON u.id = ct.contact_id
+		WHERE ct.owner_id = ?
+		ORDER BY ct.favourite DESC, COALESCE(ct.nickname, u.display_name, u.username) COLLATE NOCASE`,
+		DeletedUsername, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []Contact{}
+	for rows.Next() {
+		var c Contact
+		if err := rows.Scan(append(c.scanTargets(), &c.Nickname, &c.Favourite, &c.ChatId)...); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+	return contacts, rows.Err()
+}
+
+// Getting the nicknames given by a user to their contacts, by contact id
+func (db *appdbimpl) GetContactNicknames(ownerId int) (map[int]string, error) {
+	rows, err := db.c.method("GetContactNicknames").Query(`
+		SELECT contact_id, nickname FROM contacts WHERE owner_id = ? AND nickname IS NOT NULL`, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nicknames := make(map[int]string)
+	for rows.Next() {
+		var contactId int
+		var nickname string
+		if err := rows.Scan(&contactId, &nickname); err != nil {
+			return nil, err
+		}
+		nicknames[contactId] = nickname
+	}
+	return nicknames, rows.Err()
+}
+
+// Checking if contactId is in the contact list of ownerId
+func (db *appdbimpl) IsContact(ownerId int, contactId int) (bool, error) {
+	var contact bool
+	err := db.c.method("IsContact").QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM contacts WHERE owner_id = ? AND contact_id = ?)`,
+		ownerId, contactId).Scan(&contact)
+	return contact, err
+}
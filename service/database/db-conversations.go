@@ -0,0 +1,258 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+	"wasatext/service/markup"
+)
+
+// Conversation is a chat as listed in the conversations of a user
+type Conversation struct {
+	ID          int
+	Name        string
+	GroupChat   bool
+	HasPhoto    bool
+	Unread      int
+	Mentions    int          // unread messages mentioning the user
+	LastMessage *ChatMessage // nil if the chat has no messages
+	Draft       *Draft       // nil if the user has no draft in the chat
+	Settings    ChatSettings
+
+	// MessageTimer is how long new messages last before disappearing, 0 if they don't
+	MessageTimer time.Duration
+}
+
+// ChatMessage is a message in the history of a chat
+type ChatMessage struct {
+	ID          int
+	ChatId      int
+	SenderId    int
+	SenderName  string
+	TextContent string
+	HasPhoto    bool
+	Forwarded   bool
+	Timestamp   time.Time
+	ExpiresAt   time.Time // zero if the message doesn't disappear
+	RawText     string    // the text as written, with the formatting markup
+	Entities    []markup.Entity
+	Mentions    []Mention
+	Poll        *Poll        // nil if the message is not a poll
+	System      *SystemEvent // nil if the message is not a system message
+}
+
+// chatMessageColumns are the columns of a ChatMessage, for the messages table aliased as `m` joined with the sender
+// (users table) aliased as `su`. DeletedUsername must be passed as query argument before them
+const chatMessageColumns = `m.id, m.chat_id, m.sender_id, CASE WHEN su.deleted_at IS NULL THEN su.username ELSE ? END,
+	m.text_message, m.gif_photo IS NOT NULL AND length(m.gif_photo) > 0, m.forwarded, m.timestamp, m.expires_at,
+	COALESCE(m.raw_text, m.text_message), ` + entityColumn + `, ` + mentionColumn + `, ` + pollColumn + `, ` + systemColumn
+
+// Getting the conversations of a user, with the number of unread messages (system messages are never unread) and of
+// those mentioning the user, the last message, the draft and the settings of the user: pinned chats first (in their
+// order), then the most recent. Archived chats are included only if requested
+func (db *appdbimpl) GetConversations(userId int, archived bool) ([]Conversation, error) {
+	now := expiryNow()
+	rows, err := db.c.method("GetConversations").Query(`
+		SELECT c.id, c.name, c.group_chat, c.gif_photo IS NOT NULL AND length(c.gif_photo) > 0, COALESCE(c.message_ttl, 0),
+			(SELECT COUNT(*) FROM message_status s JOIN messages um ON um.id = s.message_id
+				WHERE s.user_id = cm.user_id AND um.chat_id = c.id AND um.sender_id != cm.user_id AND NOT s.seen
+					AND `+notExpired("um")+`),
+			(SELECT COUNT(DISTINCT mm.message_id) FROM message_mentions mm
+				JOIN message_status s ON s.message_id = mm.message_id AND s.user_id = mm.user_id
+				JOIN messages um ON um.id = mm.message_id
+				WHERE mm.user_id = cm.user_id AND mm.chat_id = c.id AND um.sender_id != cm.user_id AND NOT s.seen
+					AND `+notExpired("um")+`),
+			m.id, m.chat_id, m.sender_id, CASE WHEN su.deleted_at IS NULL THEN su.username ELSE ? END,
+			m.text_message, m.gif_photo IS NOT NULL AND length(m.gif_photo) > 0, m.forwarded, m.timestamp, m.expires_at,
+			COALESCE(m.raw_text, m.text_message),
+			CASE WHEN m.id IS NULL THEN NULL ELSE `+entityColumn+` END,
+			CASE WHEN m.id IS NULL THEN NULL ELSE `+mentionColumn+` END,
+			CASE WHEN m.id IS NULL THEN NULL ELSE `+pollColumn+` END,
+			`+systemColumn+`,
+			cm.muted_until, cm.pin_order IS NOT NULL, cm.archived, cm.marked_unread,
+			COALESCE(cm.draft_text, ''), `+draftReplyTo+`, cm.draft_updated_at
+		FROM chat_members cm
+		JOIN chats c ON c.id = cm.chat_id
+		LEFT JOIN messages m ON m.id = (
+			SELECT id FROM messages WHERE chat_id = c.id AND `+notExpired("messages")+`
+			ORDER BY timestamp DESC, id DESC LIMIT 1)
+		LEFT JOIN users su ON su.id = m.sender_id
+		WHERE cm.user_id = ? AND (? OR NOT cm.archived)
+		ORDER BY cm.pin_order IS NULL, cm.pin_order DESC, m.timestamp IS NULL, m.timestamp DESC, c.id DESC`,
+		now, now, DeletedUsername, now, now, userId, archived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		var c Conversation
+		var groupChat sql.NullBool
+		var id, chatId, senderId sql.NullInt64
+		var senderName, text, rawText, entities, mentions, poll, system sql.NullString
+		var hasPhoto, forwarded sql.NullBool
+		var timestamp, expiresAt, mutedUntil, draftUpdatedAt sql.NullTime
+		var draftText string
+		var draftReplyTo sql.NullInt64
+		var messageTimer int64
+		err := rows.Scan(&c.ID, &c.Name, &groupChat, &c.HasPhoto, &messageTimer, &c.Unread, &c.Mentions,
+			&id, &chatId, &senderId, &senderName, &text, &hasPhoto, &forwarded, &timestamp, &expiresAt,
+			&rawText, &entities, &mentions, &poll, &system, &mutedUntil, &c.Settings.Pinned, &c.Settings.Archived, &c.Settings.MarkedUnread,
+			&draftText, &draftReplyTo, &draftUpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		c.GroupChat = groupChat.Bool
+		c.Settings.MutedUntil = mutedUntil.Time
+		c.MessageTimer = time.Duration(messageTimer) * time.Second
+		if draftUpdatedAt.Valid {
+			c.Draft = &Draft{Text: draftText, ReplyTo: int(draftReplyTo.Int64), UpdatedAt: draftUpdatedAt.Time}
+		}
+		if id.Valid {
+			lastEntities, err := parseEntities(entities)
+			if err != nil {
+				return nil, err
+			}
+			lastMentions, err := parseMentions(mentions)
+			if err != nil {
+				return nil, err
+			}
+			lastPoll, err := parsePoll(poll)
+			if err != nil {
+				return nil, err
+			}
+			lastSystem, err := parseSystemEvent(system)
+			if err != nil {
+				return nil, err
+			}
+			c.LastMessage = &ChatMessage{
+				ID:          int(id.Int64),
+				ChatId:      int(chatId.Int64),
+				SenderId:    int(senderId.Int64),
+				SenderName:  senderName.String,
+				TextContent: text.String,
+				HasPhoto:    hasPhoto.Bool,
+				Forwarded:   forwarded.Bool,
+				Timestamp:   timestamp.Time,
+				ExpiresAt:   expiresAt.Time,
+				RawText:     rawText.String,
+				Entities:    lastEntities,
+				Mentions:    lastMentions,
+				Poll:        lastPoll,
+				System:      lastSystem,
+			}
+		}
+		conversations = append(conversations, c)
+	}
+	return conversations, rows.Err()
+}
+
+// Getting the messages of a chat, oldest first
+func (db *appdbimpl) GetChatMessageList(chatId int) ([]ChatMessage, error) {
+	rows, err := db.c.method("GetChatMessageList").Query(`
+		SELECT `+chatMessageColumns+`
+		FROM messages m JOIN users su ON su.id = m.sender_id
+		WHERE m.chat_id = ? AND `+notExpired("m")+`
+		ORDER BY m.timestamp, m.id`, DeletedUsername, chatId, expiryNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// Getting a page of the messages of a chat: the offset counts from the most recent message, while the page is sorted
+// oldest first
+func (db *appdbimpl) GetChatMessagePage(chatId int, limit int, offset int) ([]ChatMessage, error) {
+	rows, err := db.c.method("GetChatMessagePage").Query(`
+		SELECT `+chatMessageColumns+`
+		FROM messages m JOIN users su ON su.id = m.sender_id
+		WHERE m.id IN (
+			SELECT id FROM messages WHERE chat_id = ? AND `+notExpired("messages")+`
+			ORDER BY timestamp DESC, id DESC
+			LIMIT ? OFFSET ?)
+		ORDER BY m.timestamp, m.id`, DeletedUsername, chatId, expiryNow(), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// Getting a message of a chat. It returns sql.ErrNoRows if the message doesn't exist in the chat
+func (db *appdbimpl) GetChatMessage(chatId int, messageId int) (ChatMessage, error) {
+	return scanChatMessage(db.c.method("GetChatMessage").QueryRow(`
+		SELECT `+chatMessageColumns+`
+		FROM messages m JOIN users su ON su.id = m.sender_id
+		WHERE m.chat_id = ? AND m.id = ? AND `+notExpired("m"), DeletedUsername, chatId, messageId, expiryNow()))
+}
+
+// scanChatMessage scans a row of chatMessageColumns, followed by the extra columns (if any)
+func scanChatMessage(row interface{ Scan(...interface{}) error }, extra ...interface{}) (ChatMessage, error) {
+	var m ChatMessage
+	var text sql.NullString
+	var forwarded sql.NullBool
+	var expiresAt sql.NullTime
+	var rawText, entities, mentions, poll, system sql.NullString
+	dest := []interface{}{&m.ID, &m.ChatId, &m.SenderId, &m.SenderName, &text, &m.HasPhoto, &forwarded, &m.Timestamp,
+		&expiresAt, &rawText, &entities, &mentions, &poll, &system}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return m, err
+	}
+	if m.Entities, err = parseEntities(entities); err != nil {
+		return m, err
+	}
+	if m.Mentions, err = parseMentions(mentions); err != nil {
+		return m, err
+	}
+	if m.Poll, err = parsePoll(poll); err != nil {
+		return m, err
+	}
+	if m.System, err = parseSystemEvent(system); err != nil {
+		return m, err
+	}
+	m.ExpiresAt = expiresAt.Time
+	m.TextContent = text.String
+	m.RawText = rawText.String
+	m.Forwarded = forwarded.Bool
+	return m, err
+}
+
+// Marking the given messages of a chat as received and seen by the user, clearing the manual unread marker
+func (db *appdbimpl) MarkChatSeen(userId int, chatId int, messageIds []int) error {
+	ids, err := json.Marshal(messageIds)
+	if err != nil {
+		return err
+	}
+	_, err = db.c.method("MarkChatSeen").Exec(`
+		UPDATE message_status SET sent = true, seen = true
+		WHERE user_id = ? AND NOT seen
+			AND message_id IN (SELECT id FROM messages WHERE chat_id = ? AND id IN (SELECT value FROM json_each(?)))`,
+		userId, chatId, string(ids))
+	if err != nil {
+		return err
+	}
+	_, err = db.c.method("MarkChatSeen").Exec(`
+		UPDATE chat_members SET marked_unread = false WHERE user_id = ? AND chat_id = ?`, userId, chatId)
+	return err
+}
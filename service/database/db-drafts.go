@@ -0,0 +1,52 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Draft is the message a member is writing in a chat, kept so that the user can continue it on their other devices
+type Draft struct {
+	Text      string // as written, with the formatting markup
+	ReplyTo   int    // the message the draft replies to, 0 if none (or if it was deleted or disappeared)
+	UpdatedAt time.Time
+}
+
+// draftReplyTo is the expression with the message the draft of a member (of the chat_members table aliased as `cm`)
+// replies to, NULL if the message doesn't exist anymore. expiryNow() must be passed as query argument
+var draftReplyTo = `(SELECT rm.id FROM messages rm
+	WHERE rm.id = cm.draft_reply_to AND rm.chat_id = cm.chat_id AND ` + notExpired("rm") + `)`
+
+// Getting the draft of a member in a chat. It returns sql.ErrNoRows if there's none (or the user is not a member)
+func (db *appdbimpl) GetDraft(userId int, chatId int) (Draft, error) {
+	var d Draft
+	var replyTo sql.NullInt64
+	err := db.c.method("GetDraft").QueryRow(`
+		SELECT COALESCE(cm.draft_text, ''), `+draftReplyTo+`, cm.draft_updated_at
+		FROM chat_members cm WHERE cm.user_id = ? AND cm.chat_id = ? AND cm.draft_updated_at IS NOT NULL`,
+		expiryNow(), userId, chatId).Scan(&d.Text, &replyTo, &d.UpdatedAt)
+	d.ReplyTo = int(replyTo.Int64)
+	return d, err
+}
+
+// Saving the draft of a member in a chat, replacing the previous one
+func (db *appdbimpl) SetDraft(userId int, chatId int, draft Draft) error {
+	_, err := db.c.method("SetDraft").Exec(`
+		UPDATE chat_members SET draft_text = ?, draft_reply_to = ?, draft_updated_at = ?
+		WHERE user_id = ? AND chat_id = ?`,
+		draft.Text, sql.NullInt64{Int64: int64(draft.ReplyTo), Valid: draft.ReplyTo != 0}, draft.UpdatedAt.UTC(),
+		userId, chatId)
+	return err
+}
+
+// Deleting the draft of a member in a chat. It reports whether there was one
+func (db *appdbimpl) DeleteDraft(userId int, chatId int) (bool, error) {
+	res, err := db.c.method("DeleteDraft").Exec(`
+		UPDATE chat_members SET draft_text = NULL, draft_reply_to = NULL, draft_updated_at = NULL
+		WHERE user_id = ? AND chat_id = ? AND draft_updated_at IS NOT NULL`, userId, chatId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"wasatext/service/markup"
+)
+
+// entityColumn is the column with the formatting entities of a message (of the messages table aliased as `m`), as a
+// JSON array
+const entityColumn = `(SELECT json_group_array(json_object('type', type, 'offset', start, 'length', length, 'url', url))
+	FROM (SELECT type, start, length, url FROM message_entities WHERE message_id = m.id ORDER BY rowid))`
+
+// insertEntities stores the formatting entities of a message being sent, in their order
+func insertEntities(tx *timedTx, messageId int, entities []markup.Entity) error {
+	for _, e := range entities {
+		_, err := tx.Exec(`INSERT INTO message_entities (message_id, type, start, length, url) VALUES (?, ?, ?, ?, ?)`,
+			messageId, e.Type, e.Offset, e.Length, sql.NullString{String: e.URL, Valid: e.URL != ""})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseEntities decodes an entityColumn value
+func parseEntities(value sql.NullString) ([]markup.Entity, error) {
+	entities := []markup.Entity{}
+	if !value.Valid {
+		return entities, nil
+	}
+	err := json.Unmarshal([]byte(value.String), &entities)
+	return entities, err
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// notExpired returns the condition excluding the expired messages (of the messages table aliased as alias), which
+// are hidden before the sweeper deletes them. The current time (see expiryNow) must be passed as query argument
+func notExpired(alias string) string {
+	return `(` + alias + `.expires_at IS NULL OR ` + alias + `.expires_at > ?)`
+}
+
+// expiryNow returns the current time to compare with the expirations. They're stored in UTC, so that they can be
+// compared as text
+func expiryNow() time.Time {
+	return globaltime.Now().UTC()
+}
+
+// Getting how long new messages of a chat last, 0 if they don't disappear
+func (db *appdbimpl) GetMessageTimer(chatId int) (time.Duration, error) {
+	var ttl sql.NullInt64
+	err := db.c.method("GetMessageTimer").QueryRow(`SELECT message_ttl FROM chats WHERE id = ?`, chatId).Scan(&ttl)
+	return time.Duration(ttl.Int64) * time.Second, err
+}
+
+// Setting how long new messages of a chat last (0 disables the timer). Messages already sent are not affected
+func (db *appdbimpl) SetMessageTimer(chatId int, timer time.Duration) error {
+	ttl := sql.NullInt64{Int64: int64(timer / time.Second), Valid: timer > 0}
+	_, err := db.c.method("SetMessageTimer").Exec(`UPDATE chats SET message_ttl = ? WHERE id = ?`, ttl, chatId)
+	return err
+}
+
+// Deleting up to limit messages expired at the given time. Their media are in the message rows, while their statuses,
+// pins, stars, mentions, formatting, polls and subjects are removed by the foreign keys. It returns the number of
+// deleted messages
+func (db *appdbimpl) DeleteExpiredMessages(now time.Time, limit int) (int, error) {
+	res, err := db.c.method("DeleteExpiredMessages").Exec(`
+		DELETE FROM messages WHERE id IN (
+			SELECT id FROM messages WHERE expires_at <= ? ORDER BY expires_at, id LIMIT ?)`, now.UTC(), limit)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
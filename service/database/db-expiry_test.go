@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// checkVisible checks which messages of the chat can be read, in the chat, by id, pinned and starred
+func checkVisible(t *testing.T, db AppDatabase, chatId int, userId int, want ...int) {
+	t.Helper()
+	messages, err := db.GetChatMessageList(chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	if len(ids) != len(want) {
+		t.Fatalf("messages = %v, want %v", ids, want)
+	}
+	for i := range ids {
+		if ids[i] != want[i] {
+			t.Fatalf("messages = %v, want %v", ids, want)
+		}
+	}
+
+	for _, id := range want {
+		if _, err := db.GetChatMessage(chatId, id); err != nil {
+			t.Errorf("GetChatMessage(%d): %v", id, err)
+		}
+	}
+	pins, err := db.GetPinnedMessages(chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stars, err := db.GetStarredMessages(userId, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != len(want) || len(stars) != len(want) {
+		t.Errorf("%d pinned and %d starred messages, want %d", len(pins), len(stars), len(want))
+	}
+}
+
+func TestExpiredMessages(t *testing.T) {
+	db := newTestDatabase(t)
+	chatId, users := newTestGroup(t, db, "alice", "bobby")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	globaltime.FixedTime = now
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	send := func() int {
+		t.Helper()
+		messageId, err := db.SendMessage(chatId, users[0], "Hello", nil, false, globaltime.Now(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.PinMessage(chatId, messageId, users[0], 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.StarMessage(users[1], chatId, messageId); err != nil {
+			t.Fatal(err)
+		}
+		return messageId
+	}
+	kept := send()
+	if err := db.SetMessageTimer(chatId, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	expiring := send()
+	checkVisible(t, db, chatId, users[1], kept, expiring)
+
+	// Past its expiration the message can't be read, even if the sweep hasn't removed it yet
+	globaltime.FixedTime = now.Add(time.Hour)
+	checkVisible(t, db, chatId, users[1], kept)
+	if _, err := db.GetChatMessage(chatId, expiring); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetChatMessage of an expired message: %v, want sql.ErrNoRows", err)
+	}
+
+	if deleted, err := db.DeleteExpiredMessages(now.Add(time.Minute), 10); err != nil || deleted != 0 {
+		t.Errorf("DeleteExpiredMessages before the expiration = %d, %v; want 0", deleted, err)
+	}
+	if deleted, err := db.DeleteExpiredMessages(now.Add(time.Hour), 10); err != nil || deleted != 1 {
+		t.Errorf("DeleteExpiredMessages = %d, %v; want 1", deleted, err)
+	}
+
+	// Back before the expiration: the message is gone, with its pin and star
+	globaltime.FixedTime = now
+	checkVisible(t, db, chatId, users[1], kept)
+}
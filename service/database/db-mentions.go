@@ -0,0 +1,64 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// Mention is a `@username` token of a message text resolved to a member of the chat. Offset and Length are in UTF-16
+// code units of the plain text, as used by the web UI
+type Mention struct {
+	UserId int `json:"userId"`
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+}
+
+// mentionColumn is the column with the mentions of a message (of the messages table aliased as `m`), as a JSON array
+const mentionColumn = `(SELECT json_group_array(json_object('userId', user_id, 'offset', start, 'length', length))
+	FROM (SELECT user_id, start, length FROM message_mentions WHERE message_id = m.id ORDER BY start))`
+
+// insertMentions stores the mentions of a message being sent, resolved by the caller on the plain text. Users who are
+// not members of the chat (anymore) are skipped
+func insertMentions(tx *timedTx, chatId int, messageId int, mentions []Mention) error {
+	for _, mention := range mentions {
+		_, err := tx.Exec(`
+			INSERT INTO message_mentions (message_id, chat_id, user_id, start, length)
+			SELECT ?, ?, ?, ?, ? WHERE EXISTS (SELECT 1 FROM chat_members WHERE chat_id = ? AND user_id = ?)`,
+			messageId, chatId, mention.UserId, mention.Offset, mention.Length, chatId, mention.UserId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMentions decodes a mentionColumn value
+func parseMentions(value sql.NullString) ([]Mention, error) {
+	mentions := []Mention{}
+	if !value.Valid {
+		return mentions, nil
+	}
+	err := json.Unmarshal([]byte(value.String), &mentions)
+	return mentions, err
+}
+
+// Getting the users mentioned in a message, except its sender
+func (db *appdbimpl) GetMentionedUsers(messageId int) ([]int, error) {
+	rows, err := db.c.method("GetMentionedUsers").Query(`
+		SELECT DISTINCT mm.user_id FROM message_mentions mm JOIN messages m ON m.id = mm.message_id
+		WHERE mm.message_id = ? AND mm.user_id != m.sender_id`, messageId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []int{}
+	for rows.Next() {
+		var userId int
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		users = append(users, userId)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+	"wasatext/service/metrics"
+)
+
+var dbQueryDuration = metrics.NewHistogramVec(
+	"wasatext_db_query_duration_seconds",
+	"Duration of database queries, partitioned by AppDatabase method.",
+	[]float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	"method",
+)
+
+var dbQueryErrors = metrics.NewCounterVec(
+	"wasatext_db_query_errors_total",
+	"Number of failed database queries, partitioned by AppDatabase method.",
+	"method",
+)
+
+var messagesSent = metrics.NewCounterVec(
+	"wasatext_messages_sent_total",
+	"Number of messages stored in conversations.",
+	"kind",
+)
+
+// timedDB wraps the SQL connection. Statements are run through the handle returned by method, which records their
+// duration in dbQueryDuration.
+type timedDB struct {
+	db *sql.DB
+}
+
+// method returns a handle running statements on the connection, labelled with the name of the AppDatabase method
+func (t timedDB) method(name string) timedConn {
+	return timedConn{db: t.db, method: name}
+}
+
+func (t timedDB) Ping() error {
+	return t.db.Ping()
+}
+
+// timedConn runs statements on the connection and times them. Methods have the same signature as the sql.DB ones,
+// except that Begin returns a timedTx, whose statements are labelled with the same method name.
+type timedConn struct {
+	db     *sql.DB
+	method string
+}
+
+func (c timedConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := c.db.Exec(query, args...)
+	observeQuery(c.method, start, err)
+	return res, err
+}
+
+func (c timedConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := c.db.Query(query, args...)
+	observeQuery(c.method, start, err)
+	return rows, err
+}
+
+// QueryRow errors are deferred to Scan, so they are not counted here
+func (c timedConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := c.db.QueryRow(query, args...)
+	observeQuery(c.method, start, nil)
+	return row
+}
+
+func (c timedConn) Begin() (*timedTx, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		dbQueryErrors.Inc(c.method)
+		return nil, err
+	}
+	return &timedTx{Tx: tx, method: c.method}, nil
+}
+
+// timedTx is a transaction whose statements are timed like the timedConn ones
+type timedTx struct {
+	*sql.Tx
+	method string
+}
+
+func (tx *timedTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := tx.Tx.Exec(query, args...)
+	observeQuery(tx.method, start, err)
+	return res, err
+}
+
+func (tx *timedTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := tx.Tx.Query(query, args...)
+	observeQuery(tx.method, start, err)
+	return rows, err
+}
+
+func (tx *timedTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := tx.Tx.QueryRow(query, args...)
+	observeQuery(tx.method, start, nil)
+	return row
+}
+
+// observeQuery records the time elapsed since start and, if the statement failed, the error
+func observeQuery(method string, start time.Time, err error) {
+	dbQueryDuration.Observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		dbQueryErrors.Inc(method)
+	}
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"wasatext/service/globaltime"
+	"wasatext/service/metrics"
+)
+
+func TestQueriesLabelledWithMethod(t *testing.T) {
+	db := newTestDatabase(t)
+	chatId, users := newTestGroup(t, db, "alice", "bobby")
+
+	// SendMessage runs its statements in a transaction
+	if _, err := db.SendMessage(chatId, users[0], "hello", nil, false, globaltime.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetUserKey(users[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	metrics.DefaultRegistry.WriteText(&b)
+	for _, method := range []string{"SendMessage", "GetUserKey", "CreateUser"} {
+		if !strings.Contains(b.String(), `wasatext_db_query_duration_seconds_count{method="`+method+`"}`) {
+			t.Errorf("no query duration recorded for %s", method)
+		}
+	}
+}
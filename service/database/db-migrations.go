@@ -0,0 +1,399 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations upgrade the database schema one version at a time: migrations[i] upgrades the schema from version i+1 to
+// version i+2. Version 1 is the base structure created in New(). The current version is stored in the SQLite
+// `user_version` pragma.
+// Never change or remove a migration which has been released: append a new one instead.
+var migrations = []func(tx *sql.Tx) error{
+	// 1 -> 2: table used by the readiness probe to verify that the storage is writable
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE healthcheck (
+			id INTEGER NOT NULL PRIMARY KEY CHECK (id = 1),
+			checked_at DATETIME NOT NULL
+		);`)
+		return err
+	},
+	// 2 -> 3: optional passphrase (bcrypt hash) for users
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE users ADD COLUMN password_hash TEXT NULL;`)
+		return err
+	},
+	// 3 -> 4: TOTP two-factor authentication and recovery codes
+	func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`ALTER TABLE users ADD COLUMN totp_secret TEXT NULL;`,
+			`ALTER TABLE users ADD COLUMN totp_enabled BOOL NOT NULL DEFAULT false;`,
+			`ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;`,
+			`CREATE TABLE recovery_codes (
+				user_id INTEGER NOT NULL,
+				code_hash TEXT NOT NULL,
+				PRIMARY KEY (user_id, code_hash),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			);`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 4 -> 5: identities from the OpenID Connect provider
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE oidc_identities (
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id INTEGER NOT NULL UNIQUE,
+			PRIMARY KEY (issuer, subject),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		);`)
+		return err
+	},
+	// 5 -> 6: deleted (anonymised) accounts
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE users ADD COLUMN deleted_at DATETIME NULL;`)
+		return err
+	},
+	// 6 -> 7: user directory search (case-insensitive prefix index, blocked users)
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE INDEX users_username_nocase ON users (username COLLATE NOCASE);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE TABLE blocked_users (
+			blocker_id INTEGER NOT NULL,
+			blocked_id INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (blocker_id, blocked_id),
+			FOREIGN KEY (blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (blocked_id) REFERENCES users(id) ON DELETE CASCADE
+		);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE INDEX blocked_users_blocked ON blocked_users (blocked_id);`)
+		return err
+	},
+	// 7 -> 8: presence (last seen and its visibility)
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE users ADD COLUMN last_seen DATETIME NULL;`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`ALTER TABLE users ADD COLUMN last_seen_privacy TEXT NOT NULL DEFAULT 'everyone';`)
+		return err
+	},
+	// 8 -> 9: profile (display name, bio and status line)
+	func(tx *sql.Tx) error {
+		for _, column := range []string{"display_name", "bio", "status"} {
+			if _, err := tx.Exec(`ALTER TABLE users ADD COLUMN ` + column + ` TEXT NULL;`); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 9 -> 10: contacts, with private nicknames and favourites
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE contacts (
+			owner_id INTEGER NOT NULL,
+			contact_id INTEGER NOT NULL,
+			nickname TEXT NULL,
+			favourite BOOL NOT NULL DEFAULT false,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (owner_id, contact_id),
+			FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (contact_id) REFERENCES users(id) ON DELETE CASCADE
+		);`)
+		return err
+	},
+	// 10 -> 11: per-member chat settings (mute, pin, archive and manual unread marker)
+	func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`ALTER TABLE chat_members ADD COLUMN muted_until DATETIME NULL`,
+			`ALTER TABLE chat_members ADD COLUMN pin_order INTEGER NULL`,
+			`ALTER TABLE chat_members ADD COLUMN archived BOOL NOT NULL DEFAULT false`,
+			`ALTER TABLE chat_members ADD COLUMN marked_unread BOOL NOT NULL DEFAULT false`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 11 -> 12: member roles and pinned messages. Members of existing groups become admins, as nobody knows who
+	// created them
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE chat_members ADD COLUMN role TEXT NOT NULL DEFAULT 'member';`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE chat_members SET role = 'admin' WHERE chat_id IN (SELECT id FROM chats WHERE group_chat);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE TABLE pinned_messages (
+			chat_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL PRIMARY KEY,
+			pinned_by INTEGER NOT NULL,
+			pinned_at DATETIME NOT NULL,
+			FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (pinned_by) REFERENCES users(id)
+		);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE INDEX pinned_messages_chat ON pinned_messages (chat_id, pinned_at);`)
+		return err
+	},
+	// 12 -> 13: starred messages
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE starred_messages (
+			user_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			chat_id INTEGER NOT NULL,
+			starred_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, message_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE
+		);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE INDEX starred_messages_user ON starred_messages (user_id, starred_at);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE INDEX starred_messages_message ON starred_messages (message_id);`)
+		return err
+	},
+	// 13 -> 14: scheduled messages, waiting to be sent
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE scheduled_messages (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			sender_id INTEGER NOT NULL,
+			text_message TEXT,
+			gif_photo BLOB,
+			send_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+			FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE
+		);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE INDEX scheduled_messages_send_at ON scheduled_messages (send_at);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE INDEX scheduled_messages_sender ON scheduled_messages (sender_id, chat_id);`)
+		return err
+	},
+	// 14 -> 15: disappearing messages (per-chat timer, expiration of each message)
+	func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`ALTER TABLE chats ADD COLUMN message_ttl INTEGER NULL;`,
+			`ALTER TABLE messages ADD COLUMN expires_at DATETIME NULL;`,
+			`CREATE INDEX messages_expires_at ON messages (expires_at) WHERE expires_at IS NOT NULL;`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 15 -> 16: mentions of chat members in messages
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE message_mentions (
+			message_id INTEGER NOT NULL,
+			chat_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			start INTEGER NOT NULL,
+			length INTEGER NOT NULL,
+			PRIMARY KEY (message_id, start),
+			FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+			FOREIGN KEY (chat_id) REFERENCES chats(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE INDEX message_mentions_user ON message_mentions (user_id, chat_id);`)
+		return err
+	},
+	// 16 -> 17: formatting entities, and the text of formatted messages as written
+	func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`ALTER TABLE messages ADD COLUMN raw_text TEXT NULL;`,
+			`CREATE TABLE message_entities (
+				message_id INTEGER NOT NULL,
+				type TEXT NOT NULL,
+				start INTEGER NOT NULL,
+				length INTEGER NOT NULL,
+				url TEXT NULL,
+				FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+			);`,
+			`CREATE INDEX message_entities_message ON message_entities (message_id);`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 17 -> 18: polls (the question is the text of their message), with their options and votes
+	func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`CREATE TABLE polls (
+				message_id INTEGER NOT NULL PRIMARY KEY,
+				multiple_choice BOOL NOT NULL,
+				anonymous BOOL NOT NULL,
+				closes_at DATETIME NULL,
+				closed_at DATETIME NULL,
+				FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+			);`,
+			`CREATE TABLE poll_options (
+				message_id INTEGER NOT NULL,
+				option_id INTEGER NOT NULL,
+				text TEXT NOT NULL,
+				PRIMARY KEY (message_id, option_id),
+				FOREIGN KEY (message_id) REFERENCES polls(message_id) ON DELETE CASCADE
+			);`,
+			`CREATE TABLE poll_votes (
+				message_id INTEGER NOT NULL,
+				option_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				voted_at DATETIME NOT NULL,
+				PRIMARY KEY (message_id, option_id, user_id),
+				FOREIGN KEY (message_id, option_id) REFERENCES poll_options(message_id, option_id) ON DELETE CASCADE,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			);`,
+			`CREATE INDEX poll_votes_user ON poll_votes (user_id, message_id);`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 18 -> 19: system messages for group events (the sender is the actor), with the users they are about
+	func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`ALTER TABLE messages ADD COLUMN system_type TEXT NULL;`,
+			`CREATE TABLE message_subjects (
+				message_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				PRIMARY KEY (message_id, user_id),
+				FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+				FOREIGN KEY (user_id) REFERENCES users(id)
+			);`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 19 -> 20: drafts of the members (a chat has a draft if draft_updated_at is set)
+	func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`ALTER TABLE chat_members ADD COLUMN draft_text TEXT NULL`,
+			`ALTER TABLE chat_members ADD COLUMN draft_reply_to INTEGER NULL`,
+			`ALTER TABLE chat_members ADD COLUMN draft_updated_at DATETIME NULL`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 20 -> 21: the message status referenced chats(id) instead of messages(id). The table is rebuilt with the right key, dropping the rows of
+	// messages or users that don't exist
+	func(tx *sql.Tx) error {
+		for _, stmt := range []string{
+			`CREATE TABLE message_status_new (
+				message_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				sent BOOL,
+				seen BOOL,
+				comment TEXT NOT NULL,
+				PRIMARY KEY (user_id, message_id),
+				FOREIGN KEY (user_id) REFERENCES users(id),
+				FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+			)`,
+			`INSERT INTO message_status_new (message_id, user_id, sent, seen, comment)
+				SELECT message_id, user_id, sent, seen, comment FROM message_status
+				WHERE message_id IN (SELECT id FROM messages) AND user_id IN (SELECT id FROM users)`,
+			`DROP TABLE message_status`,
+			`ALTER TABLE message_status_new RENAME TO message_status`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 21 -> 22: 11 -> 12 made every member of the existing groups admin. In the groups where all the members are
+	// still admins and nobody changed a role since, only the earliest member (the first one added) stays admin
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE chat_members SET role = 'member'
+			WHERE chat_id IN (
+				SELECT cm.chat_id FROM chat_members cm JOIN chats c ON c.id = cm.chat_id
+				WHERE c.group_chat
+				GROUP BY cm.chat_id
+				HAVING COUNT(*) > 1 AND COUNT(*) = SUM(cm.role = 'admin'))
+			AND chat_id NOT IN (SELECT chat_id FROM messages WHERE system_type = 'role_changed')
+			AND rowid NOT IN (SELECT MIN(rowid) FROM chat_members GROUP BY chat_id);`)
+		return err
+	},
+}
+
+// LatestSchemaVersion returns the schema version expected by this executable
+func LatestSchemaVersion() int {
+	return len(migrations) + 1
+}
+
+// migrate brings the database schema to LatestSchemaVersion, applying each missing migration in its own transaction
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if version == 0 {
+		// Base structure (either just created or created before versioning was introduced)
+		version = 1
+	}
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than the supported one (%d)", version, LatestSchemaVersion())
+	}
+
+	for ; version < LatestSchemaVersion(); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("starting migration to version %d: %w", version+1, err)
+		}
+		if err = migrations[version-1](tx); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrating to version %d: %w", version+1, err)
+		}
+		// PRAGMA does not support placeholders
+		if _, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("updating schema version to %d: %w", version+1, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("committing migration to version %d: %w", version+1, err)
+		}
+	}
+
+	// Needed for the base structure, when there are no migrations to apply
+	_, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version))
+	return err
+}
@@ -0,0 +1,51 @@
+package database
+
+// Retrieving the user linked to an OpenID Connect identity (sql.ErrNoRows if there is none)
+func (db *appdbimpl) GetUserIdByOidcSubject(issuer string, subject string) (int, error) {
+	var userId int
+	err := db.c.method("GetUserIdByOidcSubject").QueryRow(`
+		SELECT user_id FROM oidc_identities WHERE issuer = ? AND subject = ?`, issuer, subject).
+		Scan(&userId)
+	if err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
+// Creating a new user (without passphrase) linked to an OpenID Connect identity
+func (db *appdbimpl) CreateOidcUser(username string, securityKey string, issuer string, subject string) (int, error) {
+	tx, err := db.c.method("CreateOidcUser").Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.Exec(`INSERT INTO users (username, security_key) VALUES (?, ?)`, username, securityKey)
+	if err != nil {
+		return 0, err
+	}
+	userId, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO oidc_identities (issuer, subject, user_id) VALUES (?, ?, ?)`, issuer, subject, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	return int(userId), err
+}
+
+// Checking whether the user is linked to an OpenID Connect identity
+func (db *appdbimpl) HasOidcIdentity(userId int) (bool, error) {
+	var linked bool
+	err := db.c.method("HasOidcIdentity").QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM oidc_identities WHERE user_id = ?)`, userId).Scan(&linked)
+	return linked, err
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// PinnedMessage is a message pinned in a chat
+type PinnedMessage struct {
+	ChatMessage
+	PinnedBy int
+	PinnedAt time.Time
+}
+
+// Pinning a message of a chat. Pinning a message already pinned is not an error; it returns false, changing nothing,
+// if the chat has already max pinned messages
+func (db *appdbimpl) PinMessage(chatId int, messageId int, userId int, max int) (bool, error) {
+	tx, err := db.c.method("PinMessage").Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	// The limit is checked in the transaction, so that concurrent requests can't pin more messages
+	var full bool
+	err = tx.QueryRow(`
+		SELECT NOT EXISTS (SELECT 1 FROM pinned_messages WHERE message_id = ?2)
+			AND (SELECT COUNT(*) FROM pinned_messages WHERE chat_id = ?1) >= ?3`,
+		chatId, messageId, max).Scan(&full)
+	if err != nil {
+		return false, err
+	}
+	if full {
+		err = tx.Rollback()
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO pinned_messages (chat_id, message_id, pinned_by, pinned_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (message_id) DO NOTHING`, chatId, messageId, userId, globaltime.Now())
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	return err == nil, err
+}
+
+// Unpinning a message of a chat, reporting whether it was pinned
+func (db *appdbimpl) UnpinMessage(chatId int, messageId int) (bool, error) {
+	res, err := db.c.method("UnpinMessage").Exec(`
+		DELETE FROM pinned_messages WHERE chat_id = ? AND message_id = ?`, chatId, messageId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// Getting the pinned messages of a chat, most recently pinned first
+func (db *appdbimpl) GetPinnedMessages(chatId int) ([]PinnedMessage, error) {
+	rows, err := db.c.method("GetPinnedMessages").Query(`
+		SELECT `+chatMessageColumns+`, p.pinned_by, p.pinned_at
+		FROM pinned_messages p
+		JOIN messages m ON m.id = p.message_id
+		JOIN users su ON su.id = m.sender_id
+		WHERE p.chat_id = ? AND `+notExpired("m")+`
+		ORDER BY p.pinned_at DESC, m.id DESC`, DeletedUsername, chatId, expiryNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []PinnedMessage{}
+	for rows.Next() {
+		var p PinnedMessage
+		p.ChatMessage, err = scanChatMessage(rows, &p.PinnedBy, &p.PinnedAt)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, p)
+	}
+	return pins, rows.Err()
+}
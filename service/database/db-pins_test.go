@@ -0,0 +1,47 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPinMessageLimit(t *testing.T) {
+	db := newTestDatabase(t)
+	chatId, users := newTestGroup(t, db, "alice", "bobby")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var messageIds []int
+	for i := 0; i < 3; i++ {
+		messageId, err := db.SendMessage(chatId, users[0], "Hello", nil, false, now, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messageIds = append(messageIds, messageId)
+	}
+
+	for _, messageId := range messageIds[:2] {
+		if pinned, err := db.PinMessage(chatId, messageId, users[0], 2); err != nil || !pinned {
+			t.Errorf("PinMessage(%d) = %v, %v; want true", messageId, pinned, err)
+		}
+	}
+	if pinned, err := db.PinMessage(chatId, messageIds[2], users[0], 2); err != nil || pinned {
+		t.Errorf("PinMessage over the limit = %v, %v; want false", pinned, err)
+	}
+	// Pinning again a pinned message doesn't count against the limit
+	if pinned, err := db.PinMessage(chatId, messageIds[0], users[1], 2); err != nil || !pinned {
+		t.Errorf("PinMessage of a pinned message = %v, %v; want true", pinned, err)
+	}
+
+	pins, err := db.GetPinnedMessages(chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 2 {
+		t.Errorf("%d pinned messages, want 2", len(pins))
+	}
+	for _, p := range pins {
+		if p.PinnedBy != users[0] {
+			t.Errorf("message %d pinned by %d, want %d", p.ID, p.PinnedBy, users[0])
+		}
+	}
+}
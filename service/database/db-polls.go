@@ -0,0 +1,182 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Limits of the polls
+const (
+	MinPollOptions = 2
+	MaxPollOptions = 10
+)
+
+// Poll is the poll of a message, whose text is the question. Options are identified by their index
+type Poll struct {
+	Options        []PollOption `json:"options"`
+	MultipleChoice bool         `json:"multipleChoice"`
+	Anonymous      bool         `json:"anonymous"`
+	ClosesAt       time.Time    `json:"closesAt"` // zero if the poll has no close time
+	ClosedAt       time.Time    `json:"closedAt"` // zero if the poll hasn't been closed before its close time
+}
+
+// PollOption is an option of a poll, with the users who voted it
+type PollOption struct {
+	Text   string `json:"text"`
+	Voters []int  `json:"voters"`
+}
+
+// Closed reports whether the poll is closed at the given time
+func (p Poll) Closed(now time.Time) bool {
+	return !p.ClosedAt.IsZero() || !p.ClosesAt.IsZero() && !now.Before(p.ClosesAt)
+}
+
+// pollColumn is the column with the poll of a message (of the messages table aliased as `m`) as a JSON object, NULL
+// if the message is not a poll
+const pollColumn = `(SELECT json_object(
+		'multipleChoice', json(CASE WHEN p.multiple_choice THEN 'true' ELSE 'false' END),
+		'anonymous', json(CASE WHEN p.anonymous THEN 'true' ELSE 'false' END),
+		'closesAt', strftime('%Y-%m-%dT%H:%M:%fZ', p.closes_at),
+		'closedAt', strftime('%Y-%m-%dT%H:%M:%fZ', p.closed_at),
+		'options', json((SELECT json_group_array(json_object('text', o.text, 'voters', json((
+			SELECT json_group_array(v.user_id) FROM poll_votes v
+			WHERE v.message_id = o.message_id AND v.option_id = o.option_id))))
+			FROM (SELECT message_id, option_id, text FROM poll_options WHERE message_id = p.message_id ORDER BY option_id) o)))
+	FROM polls p WHERE p.message_id = m.id)`
+
+// parsePoll decodes a pollColumn value
+func parsePoll(value sql.NullString) (*Poll, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	var poll Poll
+	err := json.Unmarshal([]byte(value.String), &poll)
+	return &poll, err
+}
+
+// Sending a poll in a chat, returning the id of its message
+func (db *appdbimpl) CreatePoll(chatId int, senderId int, question string, options []string, multipleChoice bool, anonymous bool, closesAt time.Time, timestamp time.Time, mentions []Mention) (int, error) {
+	tx, err := db.c.method("CreatePoll").Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	messageId, err := insertMessage(tx, chatId, senderId, question, nil, false, timestamp, mentions)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`INSERT INTO polls (message_id, multiple_choice, anonymous, closes_at) VALUES (?, ?, ?, ?)`,
+		messageId, multipleChoice, anonymous, sql.NullTime{Time: closesAt.UTC(), Valid: !closesAt.IsZero()})
+	if err != nil {
+		return 0, err
+	}
+	for i, option := range options {
+		_, err = tx.Exec(`INSERT INTO poll_options (message_id, option_id, text) VALUES (?, ?, ?)`, messageId, i, option)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	messagesSent.Inc("poll")
+	return messageId, nil
+}
+
+// Replacing the votes of a user in a poll with the given options. It reports whether the poll was open at the given
+// time: votes in closed polls are not recorded
+func (db *appdbimpl) VotePoll(messageId int, userId int, optionIds []int, now time.Time) (bool, error) {
+	tx, err := db.c.method("VotePoll").Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var open bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM polls
+			WHERE message_id = ? AND closed_at IS NULL AND (closes_at IS NULL OR closes_at > ?))`,
+		messageId, now.UTC()).Scan(&open)
+	if err != nil {
+		return false, err
+	}
+	if !open {
+		err = tx.Rollback()
+		return false, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM poll_votes WHERE message_id = ? AND user_id = ?`, messageId, userId)
+	if err != nil {
+		return false, err
+	}
+	for _, optionId := range optionIds {
+		_, err = tx.Exec(`INSERT INTO poll_votes (message_id, option_id, user_id, voted_at) VALUES (?, ?, ?, ?)`,
+			messageId, optionId, userId, now)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	return err == nil, err
+}
+
+// Retracting the votes of a user in a poll. It returns false if the poll is closed at the given time: votes in
+// closed polls are not retracted. Retracting without having voted is not an error
+func (db *appdbimpl) RetractPollVote(messageId int, userId int, now time.Time) (bool, error) {
+	tx, err := db.c.method("RetractPollVote").Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var open bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM polls
+			WHERE message_id = ? AND closed_at IS NULL AND (closes_at IS NULL OR closes_at > ?))`,
+		messageId, now.UTC()).Scan(&open)
+	if err != nil {
+		return false, err
+	}
+	if !open {
+		err = tx.Rollback()
+		return false, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM poll_votes WHERE message_id = ? AND user_id = ?`, messageId, userId)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	return err == nil, err
+}
+
+// Closing a poll at the given time. It reports whether the poll was open (closing a closed poll is not an error)
+func (db *appdbimpl) ClosePoll(messageId int, closedAt time.Time) (bool, error) {
+	res, err := db.c.method("ClosePoll").Exec(`
+		UPDATE polls SET closed_at = ?
+		WHERE message_id = ? AND closed_at IS NULL AND (closes_at IS NULL OR closes_at > ?)`,
+		closedAt.UTC(), messageId, closedAt.UTC())
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
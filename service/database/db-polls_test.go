@@ -0,0 +1,75 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVotePollOnlyWhenOpen(t *testing.T) {
+	db := newTestDatabase(t)
+	chatId, users := newTestGroup(t, db, "alice", "bobby")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	messageId, err := db.CreatePoll(chatId, users[0], "Lunch?", []string{"Yes", "No"}, false, false,
+		now.Add(time.Hour), now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if voted, err := db.VotePoll(messageId, users[1], []int{0}, now); err != nil || !voted {
+		t.Errorf("VotePoll in an open poll = %v, %v; want true", voted, err)
+	}
+	if voted, err := db.VotePoll(messageId, users[0], []int{1}, now.Add(time.Hour)); err != nil || voted {
+		t.Errorf("VotePoll at the close time = %v, %v; want false", voted, err)
+	}
+
+	if _, err := db.ClosePoll(messageId, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if voted, err := db.VotePoll(messageId, users[1], []int{1}, now.Add(time.Minute)); err != nil || voted {
+		t.Errorf("VotePoll in a closed poll = %v, %v; want false", voted, err)
+	}
+
+	message, err := db.GetChatMessage(chatId, messageId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if votes := message.Poll.Options[0].Voters; len(votes) != 1 || votes[0] != users[1] {
+		t.Errorf("voters of the first option = %v, want [%d]", votes, users[1])
+	}
+	if votes := message.Poll.Options[1].Voters; len(votes) != 0 {
+		t.Errorf("voters of the second option = %v, want none", votes)
+	}
+}
+
+func TestRetractPollVoteOnlyWhenOpen(t *testing.T) {
+	db := newTestDatabase(t)
+	chatId, users := newTestGroup(t, db, "alice", "bobby")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	messageId, err := db.CreatePoll(chatId, users[0], "Lunch?", []string{"Yes", "No"}, false, false,
+		now.Add(time.Hour), now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userId := range users {
+		if _, err := db.VotePoll(messageId, userId, []int{0}, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if retracted, err := db.RetractPollVote(messageId, users[0], now); err != nil || !retracted {
+		t.Errorf("RetractPollVote in an open poll = %v, %v; want true", retracted, err)
+	}
+	if retracted, err := db.RetractPollVote(messageId, users[1], now.Add(time.Hour)); err != nil || retracted {
+		t.Errorf("RetractPollVote at the close time = %v, %v; want false", retracted, err)
+	}
+
+	message, err := db.GetChatMessage(chatId, messageId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if votes := message.Poll.Options[0].Voters; len(votes) != 1 || votes[0] != users[1] {
+		t.Errorf("voters of the first option = %v, want [%d]", votes, users[1])
+	}
+}
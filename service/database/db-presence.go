@@ -0,0 +1,56 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Getting the public information about a user. Deleted accounts are returned with DeletedUsername
+func (db *appdbimpl) GetUser(userId int) (UserInfo, error) {
+	var u UserInfo
+	var lastSeen sql.NullTime
+	err := db.c.method("GetUser").QueryRow(`
+		SELECT `+userSummaryColumns+`, COALESCE(u.bio, ''), u.deleted_at IS NOT NULL, u.last_seen, u.last_seen_privacy
+		FROM users u WHERE u.id = ?`, DeletedUsername, userId).
+		Scan(append(u.scanTargets(), &u.Bio, &u.Deleted, &lastSeen, &u.LastSeenPrivacy)...)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	u.LastSeen = lastSeen.Time
+	return u, nil
+}
+
+// Updating the last time the user was seen online
+func (db *appdbimpl) SetLastSeen(userId int, lastSeen time.Time) error {
+	_, err := db.c.method("SetLastSeen").Exec(`UPDATE users SET last_seen = ? WHERE id = ?`, lastSeen, userId)
+	return err
+}
+
+// Updating who can see the last seen timestamp of the user (one of the Privacy constants)
+func (db *appdbimpl) SetLastSeenPrivacy(userId int, privacy string) error {
+	_, err := db.c.method("SetLastSeenPrivacy").Exec(`
+		UPDATE users SET last_seen_privacy = ? WHERE id = ?`, privacy, userId)
+	return err
+}
+
+// Getting the users sharing at least one chat with the user
+func (db *appdbimpl) GetSharedChatUsers(userId int) ([]int, error) {
+	rows, err := db.c.method("GetSharedChatUsers").Query(`
+		SELECT DISTINCT other.user_id
+		FROM chat_members mine JOIN chat_members other ON other.chat_id = mine.chat_id
+		WHERE mine.user_id = ? AND other.user_id != ?`, userId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		users = append(users, id)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,63 @@
+package database
+
+import "strings"
+
+// userSummaryColumns are the columns of a UserSummary, for the users table aliased as `u`. DeletedUsername must be
+// passed as the first query argument
+const userSummaryColumns = `u.id, CASE WHEN u.deleted_at IS NULL THEN u.username ELSE ? END,
+	COALESCE(u.display_name, ''), COALESCE(u.status, ''), u.gif_photo IS NOT NULL AND length(u.gif_photo) > 0`
+
+// scanTargets returns the destinations for scanning userSummaryColumns
+func (u *UserSummary) scanTargets() []interface{} {
+	return []interface{}{&u.ID, &u.Username, &u.DisplayName, &u.Status, &u.HasPhoto}
+}
+
+// Updating the profile of the user. Nil fields are left unchanged, empty strings clear the field
+func (db *appdbimpl) UpdateProfile(userId int, displayName *string, bio *string, status *string) error {
+	var set []string
+	var args []interface{}
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{{"display_name", displayName}, {"bio", bio}, {"status", status}} {
+		if field.value == nil {
+			continue
+		}
+		set = append(set, field.column+" = ?")
+		if *field.value == "" {
+			args = append(args, nil)
+		} else {
+			args = append(args, *field.value)
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	_, err := db.c.method("UpdateProfile").Exec(`
+		UPDATE users SET `+strings.Join(set, ", ")+` WHERE id = ?`, append(args, userId)...)
+	return err
+}
+
+// Getting the members of a chat, with their profile, ordered by username
+func (db *appdbimpl) GetChatMemberProfiles(chatId int) ([]UserSummary, error) {
+	rows, err := db.c.method("GetChatMemberProfiles").Query(`
+		SELECT `+userSummaryColumns+`
+		FROM chat_members m JOIN users u ON u.id = m.user_id
+		WHERE m.chat_id = ?
+		ORDER BY u.username COLLATE NOCASE`, DeletedUsername, chatId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []UserSummary{}
+	for rows.Next() {
+		var u UserSummary
+		if err := rows.Scan(u.scanTargets()...); err != nil {
+			return nil, err
+		}
+		members = append(members, u)
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,140 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// newTestGroup creates the users and a group with them as members, added in order. The first one is the admin
+func newTestGroup(t *testing.T, db AppDatabase, usernames ...string) (int, []int) {
+	t.Helper()
+	chatId, err := db.NewChat("Group chat", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userIds []int
+	for _, username := range usernames {
+		userId, err := db.CreateUser(username, "key-"+username, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddChatMember(userId, chatId); err != nil {
+			t.Fatal(err)
+		}
+		userIds = append(userIds, userId)
+	}
+	if err := db.SetChatMemberRole(userIds[0], chatId, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	return chatId, userIds
+}
+
+func checkRoles(t *testing.T, db AppDatabase, chatId int, want map[int]string) {
+	t.Helper()
+	for userId, role := range want {
+		got, err := db.GetChatMemberRole(userId, chatId)
+		if err != nil {
+			t.Fatalf("role of user %d: %v", userId, err)
+		}
+		if got != role {
+			t.Errorf("role of user %d = %s, want %s", userId, got, role)
+		}
+	}
+}
+
+func TestLastAdminLeavingPromotesOldestMember(t *testing.T) {
+	db := newTestDatabase(t)
+	chatId, users := newTestGroup(t, db, "alice", "bobby", "carol")
+
+	// Another admin is left: nobody is promoted
+	if err := db.SetChatMemberRole(users[2], chatId, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RemoveChatMember(users[0], chatId); err != nil {
+		t.Fatal(err)
+	}
+	checkRoles(t, db, chatId, map[int]string{users[1]: RoleMember, users[2]: RoleAdmin})
+
+	if err := db.RemoveChatMember(users[2], chatId); err != nil {
+		t.Fatal(err)
+	}
+	checkRoles(t, db, chatId, map[int]string{users[1]: RoleAdmin})
+}
+
+func TestDeletedLastAdminPromotesOldestMember(t *testing.T) {
+	db := newTestDatabase(t)
+	chatId, users := newTestGroup(t, db, "alice", "bobby", "carol")
+
+	if err := db.DeleteUser(users[0], "new-key-alice"); err != nil {
+		t.Fatal(err)
+	}
+	checkRoles(t, db, chatId, map[int]string{users[1]: RoleAdmin, users[2]: RoleMember})
+
+	messages, err := db.GetChatMessageList(chatId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(messages); n == 0 || messages[n-1].System == nil || messages[n-1].System.Type != SystemMemberLeft ||
+		messages[n-1].SenderId != users[0] {
+		t.Errorf("last message = %+v, want a member_left system message by the deleted user", messages)
+	}
+}
+
+func TestMigrationKeepsOnlyEarliestAdmin(t *testing.T) {
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As left by 11 -> 12: everybody is admin
+	promoted, users := newTestGroup(t, db, "alice", "bobby", "carol")
+	for _, userId := range users[1:] {
+		if err := db.SetChatMemberRole(userId, promoted, RoleAdmin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Roles chosen by the admins are kept
+	chosen, others := newTestGroup(t, db, "dave", "erin")
+
+	if _, err := conn.Exec(`PRAGMA user_version = 21`); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = New(conn); err != nil {
+		t.Fatal(err)
+	}
+	checkRoles(t, db, promoted, map[int]string{users[0]: RoleAdmin, users[1]: RoleMember, users[2]: RoleMember})
+	checkRoles(t, db, chosen, map[int]string{others[0]: RoleAdmin, others[1]: RoleMember})
+}
+
+func TestDeleteUserRemovesBlocksAndContacts(t *testing.T) {
+	db := newTestDatabase(t)
+	_, users := newTestGroup(t, db, "alice", "bobby", "carol")
+	alice, bobby, carol := users[0], users[1], users[2]
+
+	for _, pair := range [][2]int{{alice, bobby}, {carol, alice}} {
+		if err := db.BlockUser(pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetContact(pair[1], pair[0], nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.DeleteUser(alice, "new-key-alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range [][2]int{{alice, bobby}, {carol, alice}, {bobby, alice}, {alice, carol}} {
+		if blocked, err := db.IsBlocked(pair[0], pair[1]); err != nil || blocked {
+			t.Errorf("IsBlocked(%d, %d) = %v, %v; want false", pair[0], pair[1], blocked, err)
+		}
+		if contact, err := db.IsContact(pair[0], pair[1]); err != nil || contact {
+			t.Errorf("IsContact(%d, %d) = %v, %v; want false", pair[0], pair[1], contact, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// ScheduledMessage is a message waiting to be sent at SendAt
+type ScheduledMessage struct {
+	ID          int
+	ChatId      int
+	SenderId    int
+	TextContent string
+	HasPhoto    bool
+	SendAt      time.Time
+	CreatedAt   time.Time
+}
+
+// scheduledMessageColumns are the columns of a ScheduledMessage, for the scheduled_messages table. Sending times are
+// stored in UTC, so that they can be compared as text
+const scheduledMessageColumns = `id, chat_id, sender_id, text_message, gif_photo IS NOT NULL AND length(gif_photo) > 0,
+	send_at, created_at`
+
+// Scheduling a message (text or GIF) to be sent in a chat, returning its id
+func (db *appdbimpl) ScheduleMessage(chatId int, senderId int, textContent string, photo []byte, sendAt time.Time) (int, error) {
+	res, err := db.c.method("ScheduleMessage").Exec(`
+		INSERT INTO scheduled_messages (chat_id, sender_id, text_message, gif_photo, send_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, chatId, senderId, textContent, photo, sendAt.UTC(), globaltime.Now())
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// Getting the messages scheduled by a user in a chat, first to be sent first
+func (db *appdbimpl) GetScheduledMessages(senderId int, chatId int) ([]ScheduledMessage, error) {
+	return db.queryScheduledMessages(`
+		SELECT `+scheduledMessageColumns+` FROM scheduled_messages
+		WHERE sender_id = ? AND chat_id = ?
+		ORDER BY send_at, id`, senderId, chatId)
+}
+
+// Getting a message scheduled by a user in a chat. It returns sql.ErrNoRows if there is no such message
+func (db *appdbimpl) GetScheduledMessage(senderId int, chatId int, scheduledId int) (ScheduledMessage, error) {
+	messages, err := db.queryScheduledMessages(`
+		SELECT `+scheduledMessageColumns+` FROM scheduled_messages
+		WHERE sender_id = ? AND chat_id = ? AND id = ?`, senderId, chatId, scheduledId)
+	if err == nil && len(messages) == 0 {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		return ScheduledMessage{}, err
+	}
+	return messages[0], nil
+}
+
+// Updating the text and the sending time of a scheduled message. Nil fields are left unchanged
+func (db *appdbimpl) UpdateScheduledMessage(scheduledId int, textContent *string, sendAt *time.Time) error {
+	var textValue, sendAtValue interface{}
+	if textContent != nil {
+		textValue = *textContent
+	}
+	if sendAt != nil {
+		sendAtValue = sendAt.UTC()
+	}
+	_, err := db.c.method("UpdateScheduledMessage").Exec(`
+		UPDATE scheduled_messages SET text_message = COALESCE(?, text_message), send_at = COALESCE(?, send_at)
+		WHERE id = ?`, textValue, sendAtValue, scheduledId)
+	return err
+}
+
+// Cancelling a scheduled message. Cancelling a message already sent (or cancelled) is not an error
+func (db *appdbimpl) CancelScheduledMessage(scheduledId int) error {
+	_, err := db.c.method("CancelScheduledMessage").Exec(`DELETE FROM scheduled_messages WHERE id = ?`, scheduledId)
+	return err
+}
+
+// Getting up to limit scheduled messages due at the given time, most overdue first, skipping the first offset ones
+func (db *appdbimpl) GetDueScheduledMessages(now time.Time, limit int, offset int) ([]ScheduledMessage, error) {
+	return db.queryScheduledMessages(`
+		SELECT `+scheduledMessageColumns+` FROM scheduled_messages
+		WHERE send_at <= ?
+		ORDER BY send_at, id
+		LIMIT ? OFFSET ?`, now.UTC(), limit, offset)
+}
+
+// Sending a scheduled message, with the given timestamp, returning the id of the sent message. The scheduled message
+// is removed in the same transaction, so it's sent once. The mentions are resolved by the caller on textContent:
+// false is returned if the message was cancelled or its text changed in the meantime
+func (db *appdbimpl) DispatchScheduledMessage(scheduledId int, textContent string, timestamp time.Time, mentions []Mention) (int, bool, error) {
+	tx, err := db.c.method("DispatchScheduledMessage").Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var chatId, senderId int
+	var text sql.NullString
+	var photo []byte
+	err = tx.QueryRow(`SELECT chat_id, sender_id, text_message, gif_photo FROM scheduled_messages WHERE id = ?`,
+		scheduledId).Scan(&chatId, &senderId, &text, &photo)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && text.String != textContent) {
+		_ = tx.Rollback()
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM scheduled_messages WHERE id = ?`, scheduledId)
+	if err != nil {
+		return 0, false, err
+	}
+	messageId, err := insertMessage(tx, chatId, senderId, text.String, photo, false, timestamp, mentions)
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, false, err
+	}
+	countSentMessage(photo, false)
+	return messageId, true, nil
+}
+
+// queryScheduledMessages runs a query returning scheduledMessageColumns
+func (db *appdbimpl) queryScheduledMessages(query string, args ...interface{}) ([]ScheduledMessage, error) {
+	rows, err := db.c.method("queryScheduledMessages").Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ScheduledMessage{}
+	for rows.Next() {
+		var m ScheduledMessage
+		var text sql.NullString
+		if err := rows.Scan(&m.ID, &m.ChatId, &m.SenderId, &text, &m.HasPhoto, &m.SendAt, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		m.TextContent = text.String
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
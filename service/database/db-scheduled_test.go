@@ -0,0 +1,91 @@
+package database
+
+import (
+	"testing"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// dueIds returns the ids of the scheduled messages due at now, skipping the first offset ones
+func dueIds(t *testing.T, db AppDatabase, now time.Time, offset int) []int {
+	t.Helper()
+	due, err := db.GetDueScheduledMessages(now, 10, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []int{}
+	for _, m := range due {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestDispatchScheduledMessages(t *testing.T) {
+	db := newTestDatabase(t)
+	chatId, users := newTestGroup(t, db, "alice", "bobby")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	globaltime.FixedTime = now
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	var scheduled []int
+	for i, text := range []string{"First", "Second", "Third"} {
+		id, err := db.ScheduleMessage(chatId, users[0], text, nil, now.Add(time.Duration(i+1)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scheduled = append(scheduled, id)
+	}
+	if ids := dueIds(t, db, now, 0); len(ids) != 0 {
+		t.Errorf("due before the sending times: %v, want none", ids)
+	}
+
+	// Fast-forward past all the sending times: the most overdue come first
+	now = now.Add(4 * time.Hour)
+	globaltime.FixedTime = now
+	if ids := dueIds(t, db, now, 0); len(ids) != 3 || ids[0] != scheduled[0] || ids[2] != scheduled[2] {
+		t.Fatalf("due = %v, want %v", ids, scheduled)
+	}
+	// A failing message is skipped by the dispatcher with the offset, and the others are still returned
+	if ids := dueIds(t, db, now, 1); len(ids) != 2 || ids[0] != scheduled[1] {
+		t.Errorf("due skipping one = %v, want %v", ids, scheduled[1:])
+	}
+
+	// Edited after being read as due: not sent, and still scheduled with the new text
+	edited := "Second, edited"
+	if err := db.UpdateScheduledMessage(scheduled[1], &edited, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, sent, err := db.DispatchScheduledMessage(scheduled[1], "Second", now, nil); err != nil || sent {
+		t.Errorf("DispatchScheduledMessage with the old text: sent = %v, %v; want false", sent, err)
+	}
+	if m, err := db.GetScheduledMessage(users[0], chatId, scheduled[1]); err != nil || m.TextContent != edited {
+		t.Errorf("edited message = %+v, %v; want %q still scheduled", m, err, edited)
+	}
+
+	messageId, sent, err := db.DispatchScheduledMessage(scheduled[0], "First", now, nil)
+	if err != nil || !sent {
+		t.Fatalf("DispatchScheduledMessage = %v, %v; want true", sent, err)
+	}
+	message, err := db.GetChatMessage(chatId, messageId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.SenderId != users[0] || message.TextContent != "First" || !message.Timestamp.Equal(now) {
+		t.Errorf("sent message = %+v, want First by %d at %v", message, users[0], now)
+	}
+	// Sent once: the scheduled message is gone
+	if _, sent, err := db.DispatchScheduledMessage(scheduled[0], "First", now, nil); err != nil || sent {
+		t.Errorf("DispatchScheduledMessage again = %v, %v; want false", sent, err)
+	}
+
+	// Cancelled after being read as due: not sent
+	if err := db.CancelScheduledMessage(scheduled[2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, sent, err := db.DispatchScheduledMessage(scheduled[2], "Third", now, nil); err != nil || sent {
+		t.Errorf("DispatchScheduledMessage of a cancelled message = %v, %v; want false", sent, err)
+	}
+	if ids := dueIds(t, db, now, 0); len(ids) != 1 || ids[0] != scheduled[1] {
+		t.Errorf("due at the end = %v, want [%d]", ids, scheduled[1])
+	}
+}
@@ -0,0 +1,34 @@
+package database
+
+import "strings"
+
+// likeEscaper escapes the LIKE wildcards, so that they are matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Searching the user directory: users whose username starts with prefix (case-insensitive), ordered by username.
+// The caller, deleted accounts and users who blocked the caller are excluded.
+func (db *appdbimpl) SearchUsers(callerId int, prefix string, limit int, offset int) ([]UserSummary, error) {
+	// LIKE is case-insensitive (for ASCII) and uses the users_username_nocase index for prefix patterns
+	rows, err := db.c.method("SearchUsers").Query(`
+		SELECT `+userSummaryColumns+`
+		FROM users u
+		WHERE u.username LIKE ? ESCAPE '\' AND u.deleted_at IS NULL AND u.id != ?
+			AND NOT EXISTS (SELECT 1 FROM blocked_users WHERE blocker_id = u.id AND blocked_id = ?)
+		ORDER BY u.username COLLATE NOCASE
+		LIMIT ? OFFSET ?`,
+		DeletedUsername, likeEscaper.Replace(prefix)+"%", callerId, callerId, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []UserSummary{}
+	for rows.Next() {
+		var u UserSummary
+		if err := rows.Scan(u.scanTargets()...); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// StarredMessage is a message starred by a user, with its chat
+type StarredMessage struct {
+	ChatMessage
+	ChatName  string
+	GroupChat bool
+	StarredAt time.Time
+}
+
+// Starring a message of a chat. Starring a message already starred is not an error
+func (db *appdbimpl) StarMessage(userId int, chatId int, messageId int) error {
+	_, err := db.c.method("StarMessage").Exec(`
+		INSERT INTO starred_messages (user_id, message_id, chat_id, starred_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, message_id) DO NOTHING`, userId, messageId, chatId, globaltime.Now())
+	return err
+}
+
+// Removing the star from a message. Unstarring a message not starred is not an error
+func (db *appdbimpl) UnstarMessage(userId int, messageId int) error {
+	_, err := db.c.method("UnstarMessage").Exec(`
+		DELETE FROM starred_messages WHERE user_id = ? AND message_id = ?`, userId, messageId)
+	return err
+}
+
+// Getting a page of the messages starred by a user, most recently starred first
+func (db *appdbimpl) GetStarredMessages(userId int, limit int, offset int) ([]StarredMessage, error) {
+	rows, err := db.c.method("GetStarredMessages").Query(`
+		SELECT `+chatMessageColumns+`, c.name, c.group_chat, s.starred_at
+		FROM starred_messages s
+		JOIN messages m ON m.id = s.message_id
+		JOIN users su ON su.id = m.sender_id
+		JOIN chats c ON c.id = s.chat_id
+		WHERE s.user_id = ? AND `+notExpired("m")+`
+		ORDER BY s.starred_at DESC, s.message_id DESC
+		LIMIT ? OFFSET ?`, DeletedUsername, userId, expiryNow(), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	starred := []StarredMessage{}
+	for rows.Next() {
+		var s StarredMessage
+		var groupChat sql.NullBool
+		s.ChatMessage, err = scanChatMessage(rows, &s.ChatName, &groupChat, &s.StarredAt)
+		if err != nil {
+			return nil, err
+		}
+		s.GroupChat = groupChat.Bool
+		starred = append(starred, s)
+	}
+	return starred, rows.Err()
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Types of the system messages
+const (
+	SystemMemberAdded  = "member_added"
+	SystemMemberLeft   = "member_left"
+	SystemRenamed      = "renamed"
+	SystemPhotoChanged = "photo_changed"
+	SystemRoleChanged  = "role_changed"
+)
+
+// SystemEvent is the group event recorded by a system message, whose sender is the user who caused it
+type SystemEvent struct {
+	Type     string `json:"type"`
+	Subjects []int  `json:"subjects"` // the users the event is about: the added members, the member with a new role
+	Value    string `json:"value"`    // the new name for renamed events, the new role for role_changed ones
+}
+
+// systemColumn is the column with the event of a system message (of the messages table aliased as `m`) as a JSON
+// object, NULL if the message is not a system message
+const systemColumn = `CASE WHEN m.system_type IS NULL THEN NULL ELSE json_object(
+		'type', m.system_type,
+		'value', COALESCE(m.text_message, ''),
+		'subjects', json((SELECT json_group_array(user_id)
+			FROM (SELECT user_id FROM message_subjects WHERE message_id = m.id ORDER BY user_id)))) END`
+
+// parseSystemEvent decodes a systemColumn value
+func parseSystemEvent(value sql.NullString) (*SystemEvent, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	var event SystemEvent
+	err := json.Unmarshal([]byte(value.String), &event)
+	return &event, err
+}
+
+// Adding a system message to a chat, returning its id. System messages have no status for the members, so they are
+// never unread, and disappear like the other messages if the chat has a timer
+func (db *appdbimpl) AddSystemMessage(chatId int, actorId int, systemType string, value string, subjects []int, timestamp time.Time) (int, error) {
+	tx, err := db.c.method("AddSystemMessage").Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	messageId, err := insertSystemMessage(tx, chatId, actorId, systemType, value, subjects, timestamp)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	messagesSent.Inc("system")
+	return messageId, nil
+}
+
+// insertSystemMessage adds a system message to a chat within the transaction, returning its id
+func insertSystemMessage(tx *timedTx, chatId int, actorId int, systemType string, value string, subjects []int, timestamp time.Time) (int, error) {
+	expiresAt, err := messageExpiry(tx, chatId, timestamp)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`
+		INSERT INTO messages (chat_id, sender_id, text_message, forwarded, timestamp, expires_at, system_type)
+		VALUES (?, ?, ?, false, ?, ?, ?)`,
+		chatId, actorId, sql.NullString{String: value, Valid: value != ""}, timestamp, expiresAt, systemType)
+	if err != nil {
+		return 0, err
+	}
+	messageId, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, userId := range subjects {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO message_subjects (message_id, user_id) VALUES (?, ?)`, messageId, userId)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(messageId), nil
+}
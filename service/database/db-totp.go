@@ -0,0 +1,108 @@
+package database
+
+import "database/sql"
+
+// Retrieving the TOTP secret (empty if none), whether TOTP is enabled, and the last time step used
+func (db *appdbimpl) GetTotp(userId int) (string, bool, int64, error) {
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	err := db.c.method("GetTotp").QueryRow(`
+		SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?`, userId).
+		Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return "", false, 0, err
+	}
+	return secret.String, enabled, lastStep, nil
+}
+
+// Saving a new TOTP secret, not enabled until confirmed with EnableTotp
+func (db *appdbimpl) SetTotpSecret(userId int, secret string) error {
+	_, err := db.c.method("SetTotpSecret").Exec(`
+		UPDATE users SET totp_secret = ?, totp_enabled = false, totp_last_step = 0 WHERE id = ?`,
+		secret, userId)
+	return err
+}
+
+// Enabling TOTP for the user, replacing the recovery codes
+func (db *appdbimpl) EnableTotp(userId int, step int64, recoveryCodeHashes []string) error {
+	tx, err := db.c.method("EnableTotp").Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec(`UPDATE users SET totp_enabled = true, totp_last_step = ? WHERE id = ?`, step, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userId)
+	if err != nil {
+		return err
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userId, codeHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	return err
+}
+
+// Disabling TOTP, removing the secret and the recovery codes
+func (db *appdbimpl) DisableTotp(userId int) error {
+	tx, err := db.c.method("DisableTotp").Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0 WHERE id = ?`, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userId)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	return err
+}
+
+// Consuming a TOTP time step, so that codes can't be reused: it returns false if the step (or a later one) has been
+// already used, also by a concurrent request
+func (db *appdbimpl) UseTotpStep(userId int, step int64) (bool, error) {
+	res, err := db.c.method("UseTotpStep").Exec(`
+		UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_enabled AND totp_last_step < ?`,
+		step, userId, step)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
+
+// Consuming a recovery code: it returns false if the code does not exist (or it has been already used)
+func (db *appdbimpl) UseRecoveryCode(userId int, codeHash string) (bool, error) {
+	res, err := db.c.method("UseRecoveryCode").Exec(`
+		DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?`, userId, codeHash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDatabase returns an empty database in a temporary directory
+func newTestDatabase(t *testing.T) AppDatabase {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	db, err := New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestUseTotpStepSingleUse(t *testing.T) {
+	db := newTestDatabase(t)
+	userId, err := db.CreateUser("alice", "key-alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTotpSecret(userId, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Steps can't be used before TOTP is enabled
+	if used, err := db.UseTotpStep(userId, 100); err != nil || used {
+		t.Fatalf("UseTotpStep before enabling = %v, %v; want false", used, err)
+	}
+	if err := db.EnableTotp(userId, 100, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if used, err := db.UseTotpStep(userId, 100); err != nil || used {
+		t.Errorf("UseTotpStep(enrolment step) = %v, %v; want false", used, err)
+	}
+	if used, err := db.UseTotpStep(userId, 101); err != nil || !used {
+		t.Errorf("UseTotpStep(101) = %v, %v; want true", used, err)
+	}
+	if used, err := db.UseTotpStep(userId, 101); err != nil || used {
+		t.Errorf("UseTotpStep(101) again = %v, %v; want false", used, err)
+	}
+	if used, err := db.UseTotpStep(userId, 99); err != nil || used {
+		t.Errorf("UseTotpStep(99) = %v, %v; want false", used, err)
+	}
+}
+
+func TestUseRecoveryCodeSingleUse(t *testing.T) {
+	db := newTestDatabase(t)
+	userId, err := db.CreateUser("alice", "key-alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherId, err := db.CreateUser("bobby", "key-bobby", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnableTotp(userId, 1, []string{"hash-1", "hash-2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if used, err := db.UseRecoveryCode(otherId, "hash-1"); err != nil || used {
+		t.Errorf("UseRecoveryCode by another user = %v, %v; want false", used, err)
+	}
+	if used, err := db.UseRecoveryCode(userId, "hash-1"); err != nil || !used {
+		t.Errorf("UseRecoveryCode = %v, %v; want true", used, err)
+	}
+	if used, err := db.UseRecoveryCode(userId, "hash-1"); err != nil || used {
+		t.Errorf("UseRecoveryCode again = %v, %v; want false", used, err)
+	}
+	if used, err := db.UseRecoveryCode(userId, "hash-2"); err != nil || !used {
+		t.Errorf("UseRecoveryCode(other code) = %v, %v; want true", used, err)
+	}
+
+	// Enabling TOTP again replaces the codes
+	if err := db.EnableTotp(userId, 2, []string{"hash-3"}); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := db.UseRecoveryCode(userId, "hash-2"); err != nil || used {
+		t.Errorf("UseRecoveryCode(replaced code) = %v, %v; want false", used, err)
+	}
+}
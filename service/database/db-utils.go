@@ -1,20 +1,27 @@
 package database
 
-import "time"
+import (
+	"database/sql"
+	"time"
+	"wasatext/service/markup"
+)
 
 // Verifying user existence
 func (db *appdbimpl) UserExists(username string) (bool, error) {
 	var exists bool
-	err := db.c.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)`, username).Scan(&exists)
+	err := db.c.method("UserExists").QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)`, username).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-// Creating a new user
-func (db *appdbimpl) CreateUser(username string, securityKey string) (int, error) {
-	res, err := db.c.Exec(`INSERT INTO users (username, security_key) VALUES (?, ?)`, username, securityKey)
+// Creating a new user. An empty password hash means that the user has no passphrase
+func (db *appdbimpl) CreateUser(username string, securityKey string, passwordHash string) (int, error) {
+	res, err := db.c.method("CreateUser").Exec(`
+		INSERT INTO users (username, security_key, password_hash) VALUES (?, ?, ?)`,
+		username, securityKey, sql.NullString{String: passwordHash, Valid: passwordHash != ""})
 	if err != nil {
 		return 0, err
 	}
@@ -25,27 +32,49 @@ func (db *appdbimpl) CreateUser(username string, securityKey string) (int, error
 // Retrieving the security key via user id
 func (db *appdbimpl) GetUserKey(userId int) (string, error) {
 	var securityKey string
-	err := db.c.QueryRow(`SELECT security_key FROM users WHERE ID = ?`, userId).Scan(&securityKey)
+	err := db.c.method("GetUserKey").QueryRow(`SELECT security_key FROM users WHERE ID = ?`, userId).Scan(&securityKey)
 	if err != nil {
 		return "", err
 	}
 	return securityKey, nil
 }
 
+// Retrieving the passphrase hash of the user (empty if the user has no passphrase)
+func (db *appdbimpl) GetPasswordHash(userId int) (string, error) {
+	var passwordHash sql.NullString
+	err := db.c.method("GetPasswordHash").QueryRow(`
+		SELECT password_hash FROM users WHERE id = ?`, userId).Scan(&passwordHash)
+	if err != nil {
+		return "", err
+	}
+	return passwordHash.String, nil
+}
+
+// Updating the passphrase hash of the user, along with the security key (which invalidates the previous one). Both are
+// written in the same statement, so that the old key can't stay valid with the new passphrase
+func (db *appdbimpl) SetPasswordHash(userId int, passwordHash string, securityKey string) error {
+	_, err := db.c.method("SetPasswordHash").Exec(`UPDATE users SET password_hash = ?, security_key = ? WHERE id = ?`,
+		passwordHash, securityKey, userId)
+	return err
+}
+
 // Retrieving the user id via security key
 func (db *appdbimpl) GetUserIdByKey(securityKey string) (int, error) {
 	var userId int
-	err := db.c.QueryRow(`SELECT id FROM users WHERE security_key = ?`, securityKey).Scan(&userId)
+	err := db.c.method("GetUserIdByKey").QueryRow(`
+		SELECT id FROM users WHERE security_key = ?`, securityKey).Scan(&userId)
 	if err != nil {
 		return 0, err
 	}
 	return userId, nil
 }
 
-// Getting the username of an user
+// Getting the username of an user (DeletedUsername for deleted accounts)
 func (db *appdbimpl) GetUsername(userId int) (string, error) {
 	var username string
-	err := db.c.QueryRow(`SELECT username FROM users WHERE ID = ?`, userId).Scan(&username)
+	err := db.c.method("GetUsername").QueryRow(`
+		SELECT CASE WHEN deleted_at IS NULL THEN username ELSE ? END FROM users WHERE ID = ?`,
+		DeletedUsername, userId).Scan(&username)
 	if err != nil {
 		return "", err
 	}
@@ -55,7 +84,8 @@ func (db *appdbimpl) GetUsername(userId int) (string, error) {
 // Retrieving the user id via username
 func (db *appdbimpl) GetUserIdByUsername(username string) (int, error) {
 	var userId int
-	err := db.c.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&userId)
+	err := db.c.method("GetUserIdByUsername").QueryRow(`
+		SELECT id FROM users WHERE username = ?`, username).Scan(&userId)
 	if err != nil {
 		return 0, err
 	}
@@ -64,7 +94,7 @@ func (db *appdbimpl) GetUserIdByUsername(username string) (int, error) {
 
 // Update the username
 func (db *appdbimpl) UpdateUsername(userId int, newUsername string) error {
-	_, err := db.c.Exec("UPDATE users SET username = ? WHERE id = ?", newUsername, userId)
+	_, err := db.c.method("UpdateUsername").Exec("UPDATE users SET username = ? WHERE id = ?", newUsername, userId)
 	return err
 }
 
@@ -72,7 +102,7 @@ func (db *appdbimpl) UpdateUsername(userId int, newUsername string) error {
 func (db *appdbimpl) GetUserChats(userId int) ([]int, error) {
 	var chatList []int
 
-	rows, err := db.c.Query(`SELECT chat_id FROM chat_members WHERE user_id = ?`, userId)
+	rows, err := db.c.method("GetUserChats").Query(`SELECT chat_id FROM chat_members WHERE user_id = ?`, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +125,7 @@ func (db *appdbimpl) GetUserChats(userId int) ([]int, error) {
 
 // Create a new conversation (either private / group chat)
 func (db *appdbimpl) NewChat(chatName string, groupChat bool) (int, error) {
-	res, err := db.c.Exec(`INSERT INTO chats (name, group_chat) VALUES (?, ?)`, chatName, groupChat)
+	res, err := db.c.method("NewChat").Exec(`INSERT INTO chats (name, group_chat) VALUES (?, ?)`, chatName, groupChat)
 	if err != nil {
 		return 0, err
 	}
@@ -103,29 +133,54 @@ func (db *appdbimpl) NewChat(chatName string, groupChat bool) (int, error) {
 	return int(id), err
 }
 
-// Add an user to the newly created chat
+// Add an user to the newly created chat. It returns sql.ErrNoRows if the user does not exist (or has been deleted)
 func (db *appdbimpl) AddChatMember(userId int, chatId int) error {
-	_, err := db.c.Exec(`INSERT INTO chat_members (user_id, chat_id) VALUES (?, ?)`, userId, chatId)
+	res, err := db.c.method("AddChatMember").Exec(`
+		INSERT INTO chat_members (user_id, chat_id)
+		SELECT id, ? FROM users WHERE id = ? AND deleted_at IS NULL`, chatId, userId)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
 // Checking if the user belongs to the conversation
 func (db *appdbimpl) ChatMember(userId int, chatId int) (bool, error) {
 	var exists bool
-	err := db.c.QueryRow(`SELECT EXISTS(SELECT 1 FROM chat_members WHERE user_id = ? AND chat_id = ?)`, userId, chatId).Scan(&exists)
+	err := db.c.method("ChatMember").QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM chat_members WHERE user_id = ? AND chat_id = ?)`, userId, chatId).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
+// Getting the role of a member in a chat. It returns sql.ErrNoRows if the user is not a member
+func (db *appdbimpl) GetChatMemberRole(userId int, chatId int) (string, error) {
+	var role string
+	err := db.c.method("GetChatMemberRole").QueryRow(`
+		SELECT role FROM chat_members WHERE user_id = ? AND chat_id = ?`, userId, chatId).Scan(&role)
+	return role, err
+}
+
+// Setting the role of a member in a chat
+func (db *appdbimpl) SetChatMemberRole(userId int, chatId int, role string) error {
+	_, err := db.c.method("SetChatMemberRole").Exec(`
+		UPDATE chat_members SET role = ? WHERE user_id = ? AND chat_id = ?`, role, userId, chatId)
+	return err
+}
+
 // Checking if the conversation is a group
 func (db *appdbimpl) GroupChat(chatId int) (bool, error) {
 	var groupChat bool
-	err := db.c.QueryRow(`SELECT group_chat FROM chats WHERE id = ?`, chatId).Scan(&groupChat)
+	err := db.c.method("GroupChat").QueryRow(`SELECT group_chat FROM chats WHERE id = ?`, chatId).Scan(&groupChat)
 	if err != nil {
 		return false, err
 	}
@@ -134,18 +189,24 @@ func (db *appdbimpl) GroupChat(chatId int) (bool, error) {
 
 // Updating the conversation name
 func (db *appdbimpl) SetChatName(chatId int, newName string) error {
-	_, err := db.c.Exec(`UPDATE chats SET name = ? WHERE id = ?`, newName, chatId)
+	_, err := db.c.method("SetChatName").Exec(`UPDATE chats SET name = ? WHERE id = ?`, newName, chatId)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Updating the conversation photo
+func (db *appdbimpl) SetChatPhoto(chatId int, photo []byte) error {
+	_, err := db.c.method("SetChatPhoto").Exec(`UPDATE chats SET gif_photo = ? WHERE id = ?`, photo, chatId)
+	return err
+}
+
 // Retrieving the conversation name
 func (db *appdbimpl) GetChatName(chatId int) (string, error) {
 	var chatName string
 
-	err := db.c.QueryRow(`SELECT name FROM chats WHERE id = ?`, chatId).Scan(&chatName)
+	err := db.c.method("GetChatName").QueryRow(`SELECT name FROM chats WHERE id = ?`, chatId).Scan(&chatName)
 	if err != nil {
 		return "", err
 	}
@@ -157,7 +218,7 @@ func (db *appdbimpl) GetChatName(chatId int) (string, error) {
 func (db *appdbimpl) GetChatMembers(chatId int) ([]int, error) {
 	var userList []int
 
-	rows, err := db.c.Query(`SELECT user_id FROM chat_members WHERE chat_id = ?`, chatId)
+	rows, err := db.c.method("GetChatMembers").Query(`SELECT user_id FROM chat_members WHERE chat_id = ?`, chatId)
 	if err != nil {
 		return nil, err
 	}
@@ -181,27 +242,59 @@ func (db *appdbimpl) GetChatMembers(chatId int) ([]int, error) {
 // Get the amount of currently registered users
 func (db *appdbimpl) GetUserCount() (int, error) {
 	var count int
-	err := db.c.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count)
+	err := db.c.method("GetUserCount").QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-// Removing a member from a chat
+// Removing a member from a chat, with the messages they starred or scheduled in it
 func (db *appdbimpl) RemoveChatMember(userId int, chatId int) error {
-	_, err := db.c.Exec(`
-		DELETE FROM chat_members WHERE user_id = ? AND chat_id = ?`, userId, chatId)
+	tx, err := db.c.method("RemoveChatMember").Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, stmt := range []string{
+		`DELETE FROM chat_members WHERE user_id = ? AND chat_id = ?`,
+		`DELETE FROM starred_messages WHERE user_id = ? AND chat_id = ?`,
+		`DELETE FROM scheduled_messages WHERE sender_id = ? AND chat_id = ?`,
+	} {
+		_, err = tx.Exec(stmt, userId, chatId)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = keepGroupAdmin(tx, chatId)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = tx.Commit()
+	return err
+}
+
+// keepGroupAdmin promotes the oldest member of a group to admin if no admin is left (e.g., the last one left the
+// group). Private chats have no admins
+func keepGroupAdmin(tx *timedTx, chatId int) error {
+	_, err := tx.Exec(`
+		UPDATE chat_members SET role = 'admin'
+		WHERE rowid = (SELECT MIN(rowid) FROM chat_members WHERE chat_id = ?1)
+			AND NOT EXISTS (SELECT 1 FROM chat_members WHERE chat_id = ?1 AND role = 'admin')
+			AND (SELECT group_chat FROM chats WHERE id = ?1)`, chatId)
+	return err
 }
 
 // Adding a comment to a message
 func (db *appdbimpl) AddComment(textContent string, senderId int, messageId int) error {
-	_, err := db.c.Exec(`
+	_, err := db.c.method("AddComment").Exec(`
 		UPDATE message_status SET comment = ? WHERE user_id = ? AND message_id = ?`, textContent, senderId, messageId)
 
 	if err != nil {
@@ -213,7 +306,8 @@ func (db *appdbimpl) AddComment(textContent string, senderId int, messageId int)
 
 // Removing a comment from a message
 func (db *appdbimpl) RemoveComment(senderId int, messageId int) error {
-	_, err := db.c.Exec(`UPDATE message_status SET comment = NULL WHERE user_id = ? AND message_id = ?`, senderId, messageId)
+	_, err := db.c.method("RemoveComment").Exec(`
+		UPDATE message_status SET comment = '' WHERE user_id = ? AND message_id = ?`, senderId, messageId)
 	if err != nil {
 		return err
 	}
@@ -221,11 +315,11 @@ func (db *appdbimpl) RemoveComment(senderId int, messageId int) error {
 	return nil
 }
 
-// Send a message in a conversation
-func (db *appdbimpl) SendMessage(chatId int, senderId int, textContent string, forwarded bool, timestamp time.Time) error {
-	tx, err := db.c.Begin()
+// Send a message (text or GIF) in a conversation, returning the message id
+func (db *appdbimpl) SendMessage(chatId int, senderId int, textContent string, photo []byte, forwarded bool, timestamp time.Time, mentions []Mention) (int, error) {
+	tx, err := db.c.method("SendMessage").Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer func() {
 		if err != nil {
@@ -233,17 +327,46 @@ func (db *appdbimpl) SendMessage(chatId int, senderId int, textContent string, f
 		}
 	}()
 
+	messageId, err := insertMessage(tx, chatId, senderId, textContent, photo, forwarded, timestamp, mentions)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	countSentMessage(photo, forwarded)
+	return messageId, nil
+}
+
+// insertMessage adds a message to a chat, with its status for each member, its expiration (if the chat has a timer),
+// its formatting and its mentions (unless forwarded, as they were written for another chat). The text is parsed with
+// the markup syntax: the plain text is stored along with the text as written
+func insertMessage(tx *timedTx, chatId int, senderId int, textContent string, photo []byte, forwarded bool, timestamp time.Time, mentions []Mention) (int, error) {
+	plainText, entities, err := markup.Parse(textContent)
+	if err != nil {
+		return 0, err
+	}
+	rawText := sql.NullString{String: textContent, Valid: plainText != textContent}
+
+	expiresAt, err := messageExpiry(tx, chatId, timestamp)
+	if err != nil {
+		return 0, err
+	}
+
 	res, err := tx.Exec(`
-		INSERT INTO messages (chat_id, sender_id, text_message, forwarded, timestamp) 
-		VALUES (?, ?, ?, ?, ?)`,
-		chatId, senderId, textContent, forwarded, timestamp)
+		INSERT INTO messages (chat_id, sender_id, text_message, raw_text, gif_photo, forwarded, timestamp, expires_at) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		chatId, senderId, plainText, rawText, photo, forwarded, timestamp, expiresAt)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	messageId, err := res.LastInsertId()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	_, err = tx.Exec(`
@@ -251,25 +374,55 @@ func (db *appdbimpl) SendMessage(chatId int, senderId int, textContent string, f
 		SELECT user_id, ?, '', false, false FROM chat_members WHERE chat_id = ?`,
 		messageId, chatId)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	err = tx.Commit()
-	return err
+	err = insertEntities(tx, int(messageId), entities)
+	if err != nil {
+		return 0, err
+	}
+	if !forwarded {
+		err = insertMentions(tx, chatId, int(messageId), mentions)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(messageId), nil
 }
 
-// Deleting a message
-func (db *appdbimpl) DeleteMessage(messageId int) error {
-	_, err := db.c.Exec(`DELETE FROM messages WHERE ID = ?`, messageId)
-	if err != nil {
-		return err
+// messageExpiry returns the expiration of a message sent at the given time: messages sent in chats with a timer
+// disappear after it
+func messageExpiry(tx *timedTx, chatId int, timestamp time.Time) (sql.NullTime, error) {
+	var ttl sql.NullInt64
+	err := tx.QueryRow(`SELECT message_ttl FROM chats WHERE id = ?`, chatId).Scan(&ttl)
+	if err != nil || !ttl.Valid || ttl.Int64 <= 0 {
+		return sql.NullTime{}, err
 	}
-	return nil
+	return sql.NullTime{Time: timestamp.Add(time.Duration(ttl.Int64) * time.Second).UTC(), Valid: true}, nil
+}
+
+// countSentMessage updates the sent messages metric, once the message is committed
+func countSentMessage(photo []byte, forwarded bool) {
+	switch {
+	case forwarded:
+		messagesSent.Inc("forwarded")
+	case len(photo) > 0:
+		messagesSent.Inc("photo")
+	default:
+		messagesSent.Inc("text")
+	}
+}
+
+// Deleting a message. Its statuses, pins, stars, mentions, formatting, poll and subjects are removed by the foreign
+// keys
+func (db *appdbimpl) DeleteMessage(messageId int) error {
+	_, err := db.c.method("DeleteMessage").Exec(`DELETE FROM messages WHERE id = ?`, messageId)
+	return err
 }
 
 // Viewing a message
 func (db *appdbimpl) ViewMessage(userId int, messageId int) error {
-	_, err := db.c.Exec(`
+	_, err := db.c.method("ViewMessage").Exec(`
 		UPDATE message_status SET seen = ? WHERE user_id = ? AND message_id = ?`, true, userId, messageId)
 	if err != nil {
 		return err
@@ -279,7 +432,7 @@ func (db *appdbimpl) ViewMessage(userId int, messageId int) error {
 
 // Receiving a message
 func (db *appdbimpl) ReceiveMessage(userId int, messageId int) error {
-	_, err := db.c.Exec(`
+	_, err := db.c.method("ReceiveMessage").Exec(`
 		UPDATE message_status SET sent = ? WHERE user_id = ? AND message_id = ?`, true, userId, messageId)
 	if err != nil {
 		return err
@@ -290,7 +443,8 @@ func (db *appdbimpl) ReceiveMessage(userId int, messageId int) error {
 
 // Getting the messages from a conversation
 func (db *appdbimpl) GetChatMessages(chatId int) ([]int, error) {
-	rows, err := db.c.Query(`SELECT id FROM messages WHERE chat_id = ?`, chatId)
+	rows, err := db.c.method("GetChatMessages").Query(`
+		SELECT id FROM messages WHERE chat_id = ? AND `+notExpired("messages"), chatId, expiryNow())
 	if err != nil {
 		return nil, err
 	}
@@ -310,8 +464,8 @@ func (db *appdbimpl) GetChatMessages(chatId int) ([]int, error) {
 
 // Getting the comment list from a message
 func (db *appdbimpl) GetMessageComments(messageId int) ([]int, []string, error) {
-	rows, err := db.c.Query(`
-		SELECT user_id, comment FROM message_status WHERE message_id = ? AND comment IS NOT NULL`, messageId)
+	rows, err := db.c.method("GetMessageComments").Query(`
+		SELECT user_id, comment FROM message_status WHERE message_id = ? AND comment != ''`, messageId)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -335,7 +489,7 @@ func (db *appdbimpl) GetMessageComments(messageId int) ([]int, []string, error)
 
 // Get a list of users who have seen the message
 func (db *appdbimpl) SeenMessage(messageId int) ([]int, error) {
-	rows, err := db.c.Query(`
+	rows, err := db.c.method("SeenMessage").Query(`
 		SELECT user_id FROM message_status WHERE message_id = ? AND seen = true`, messageId)
 	if err != nil {
 		return nil, err
@@ -361,8 +515,9 @@ func (db *appdbimpl) GetMessage(messageId int) (int, string, bool, time.Time, er
 	var forwarded bool
 	var timestamp time.Time
 
-	err := db.c.QueryRow(`
-		SELECT sender_id, text_message, forwarded, timestamp FROM messages WHERE id = ?`, messageId).Scan(&senderId, &textContent, &forwarded, &timestamp)
+	err := db.c.method("GetMessage").QueryRow(`
+		SELECT sender_id, text_message, forwarded, timestamp FROM messages WHERE id = ? AND `+notExpired("messages"),
+		messageId, expiryNow()).Scan(&senderId, &textContent, &forwarded, &timestamp)
 
 	if err != nil {
 		return -1, "", false, time.Time{}, err
@@ -0,0 +1,409 @@
+/*
+Package markup parses the formatting syntax of messages into plain text and a list of entities, so that every client
+renders formatting the same way:
+
+	*bold*  _italic_  `code`  [text](https://example.com)
+
+Bold and italic markers open at the start of a word and close at its end, so `snake_case` and `2*3*4` are left
+alone. Markers which are not closed are kept as text; a backslash escapes the next marker character. Code spans are not
+formatted further. Web addresses (http and https) are detected and turned into url entities. Links need an http, https
+or mailto address, otherwise they are kept as text; a link around another link is kept as text too.
+
+Offsets and lengths of the entities are in UTF-16 code units, as used by the web UI.
+*/
+package markup
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Types of the entities
+const (
+	Bold   = "bold"
+	Italic = "italic"
+	Code   = "code"
+	Link   = "link"
+	URL    = "url"
+)
+
+// MaxEntities is the maximum number of entities in a message
+const MaxEntities = 100
+
+// Entity is a formatted range of a text
+type Entity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	URL    string `json:"url,omitempty"` // links only
+}
+
+var (
+	ErrTooManyEntities = errors.New("too many formatting entities")
+	ErrInvalidLink     = errors.New("invalid link: only http, https and mailto addresses are allowed")
+	ErrNestedLink      = errors.New("links can't be nested")
+)
+
+// escapable are the characters which can be escaped with a backslash
+const escapable = "\\*_`[]()"
+
+// token kinds
+const (
+	tokenText      = iota
+	tokenMarker    // * _ [
+	tokenLinkClose // ](url)
+	tokenCode
+	tokenURL
+)
+
+type token struct {
+	kind     int
+	raw      []rune // the token as written, emitted when it's not matched
+	text     []rune // the content, for text, code and url tokens
+	marker   rune
+	url      string
+	canOpen  bool
+	canClose bool
+	match    int // index of the matching token, -1 if none
+}
+
+// Parse returns the plain text of a message written with the markup syntax, with its entities sorted by offset
+func Parse(raw string) (string, []Entity, error) {
+	tokens := tokenize([]rune(raw))
+	matchMarkers(tokens)
+
+	// Emitting the text, with the entities in runes
+	var out []rune
+	var entities []Entity
+	starts := make(map[int]int) // matched open marker -> start of the entity
+	for i, t := range tokens {
+		switch {
+		case t.kind == tokenText:
+			out = append(out, t.text...)
+		case t.kind == tokenCode || t.kind == tokenURL:
+			kind := Code
+			if t.kind == tokenURL {
+				kind = URL
+			}
+			entities = append(entities, Entity{Type: kind, Offset: len(out), Length: len(t.text)})
+			out = append(out, t.text...)
+		case t.match == -1:
+			out = append(out, t.raw...)
+		case t.match > i:
+			starts[i] = len(out)
+		default:
+			start := starts[t.match]
+			if start == len(out) {
+				// Nothing between the markers: they are kept as text
+				out = append(out, tokens[t.match].raw...)
+				out = append(out, t.raw...)
+				continue
+			}
+			entity := Entity{Offset: start, Length: len(out) - start}
+			switch {
+			case t.kind == tokenLinkClose:
+				entity.Type, entity.URL = Link, t.url
+			case t.marker == '*':
+				entity.Type = Bold
+			default:
+				entity.Type = Italic
+			}
+			entities = append(entities, entity)
+		}
+	}
+
+	// Converting the offsets to UTF-16 code units
+	offsets := make([]int, len(out)+1)
+	for i, c := range out {
+		offsets[i+1] = offsets[i] + len(utf16.Encode([]rune{c}))
+	}
+	for i := range entities {
+		end := entities[i].Offset + entities[i].Length
+		entities[i].Offset = offsets[entities[i].Offset]
+		entities[i].Length = offsets[end] - entities[i].Offset
+	}
+
+	// Outer entities first; with the same range, code spans and web addresses are the inner ones
+	sort.SliceStable(entities, func(i, j int) bool {
+		if entities[i].Offset != entities[j].Offset {
+			return entities[i].Offset < entities[j].Offset
+		}
+		if entities[i].Length != entities[j].Length {
+			return entities[i].Length > entities[j].Length
+		}
+		return !atomic(entities[i].Type) && atomic(entities[j].Type)
+	})
+
+	text := string(out)
+	entities = dropURLsInLinks(entities)
+	if err := Validate(text, entities); err != nil {
+		return "", nil, err
+	}
+	return text, entities, nil
+}
+
+// Validate checks that the entities are well-formed for the text: sorted by offset (outer entities first), within the
+// text, not empty, nested without crossing, with nothing inside code spans and web addresses, and with valid link
+// addresses
+func Validate(text string, entities []Entity) error {
+	if len(entities) > MaxEntities {
+		return ErrTooManyEntities
+	}
+
+	length := len(utf16.Encode([]rune(text)))
+	var open []Entity // the entities containing the current one
+	for i, e := range entities {
+		end := e.Offset + e.Length
+		if e.Offset < 0 || e.Length <= 0 || end > length {
+			return errors.New("formatting entity out of the text")
+		}
+		if i > 0 && (e.Offset < entities[i-1].Offset ||
+			e.Offset == entities[i-1].Offset && e.Length > entities[i-1].Length) {
+			return errors.New("formatting entities not sorted")
+		}
+
+		for len(open) > 0 && open[len(open)-1].Offset+open[len(open)-1].Length <= e.Offset {
+			open = open[:len(open)-1]
+		}
+		for _, outer := range open {
+			if end > outer.Offset+outer.Length {
+				return errors.New("formatting entities can't cross each other")
+			}
+			if atomic(outer.Type) {
+				return errors.New("code and web addresses can't be formatted")
+			}
+			if outer.Type == Link && e.Type == Link {
+				return ErrNestedLink
+			}
+		}
+
+		switch e.Type {
+		case Bold, Italic, Code, URL:
+			if e.URL != "" {
+				return errors.New("only links have an address")
+			}
+		case Link:
+			if !validLink(e.URL) {
+				return ErrInvalidLink
+			}
+		default:
+			return errors.New("unknown formatting entity")
+		}
+		open = append(open, e)
+	}
+	return nil
+}
+
+// tokenize splits the text in tokens: plain text, markers, code spans and web addresses
+func tokenize(rs []rune) []token {
+	var tokens []token
+	text := func(r ...rune) {
+		if n := len(tokens); n > 0 && tokens[n-1].kind == tokenText {
+			tokens[n-1].text = append(tokens[n-1].text, r...)
+			tokens[n-1].raw = tokens[n-1].text
+			return
+		}
+		t := append([]rune(nil), r...)
+		tokens = append(tokens, token{kind: tokenText, raw: t, text: t, match: -1})
+	}
+
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		var prev, next rune
+		if i > 0 {
+			prev = rs[i-1]
+		}
+		if i+1 < len(rs) {
+			next = rs[i+1]
+		}
+
+		switch {
+		case c == '\\' && next != 0 && strings.ContainsRune(escapable, next):
+			text(next)
+			i++
+		case c == '`':
+			end := indexRune(rs, '`', i+1)
+			if end <= i+1 {
+				text(c)
+				continue
+			}
+			tokens = append(tokens, token{kind: tokenCode, raw: rs[i : end+1], text: rs[i+1 : end], match: -1})
+			i = end
+		case c == '*' || c == '_':
+			tokens = append(tokens, token{
+				kind:     tokenMarker,
+				raw:      rs[i : i+1],
+				marker:   c,
+				canOpen:  next != 0 && !unicode.IsSpace(next) && !isWordRune(prev),
+				canClose: prev != 0 && !unicode.IsSpace(prev) && !isWordRune(next),
+				match:    -1,
+			})
+		case c == '[':
+			tokens = append(tokens, token{kind: tokenMarker, raw: rs[i : i+1], marker: c, canOpen: true, match: -1})
+		case c == ']' && next == '(':
+			// Not a link if the address isn't allowed, as in `f[0](arg)`: the brackets are kept as text
+			end := indexRune(rs, ')', i+2)
+			if end < 0 || strings.IndexFunc(string(rs[i+2:end]), unicode.IsSpace) >= 0 || !validLink(string(rs[i+2:end])) {
+				text(c)
+				continue
+			}
+			tokens = append(tokens, token{
+				kind:     tokenLinkClose,
+				raw:      rs[i : end+1],
+				url:      string(rs[i+2 : end]),
+				canClose: true,
+				match:    -1,
+			})
+			i = end
+		case (c == 'h' || c == 'H') && !isWordRune(prev):
+			n := urlLength(rs[i:])
+			if n == 0 {
+				text(c)
+				continue
+			}
+			tokens = append(tokens, token{kind: tokenURL, raw: rs[i : i+n], text: rs[i : i+n], match: -1})
+			i += n - 1
+		default:
+			text(c)
+		}
+	}
+	return tokens
+}
+
+// matchMarkers pairs the markers: a closing marker matches the nearest open one of the same kind, and the markers
+// opened after it are left unmatched. Links can't be nested: a link around another one is left unmatched
+func matchMarkers(tokens []token) {
+	var stack []int
+	lastLink := -1 // the closing marker of the last link
+	for i := range tokens {
+		t := &tokens[i]
+		if t.kind != tokenMarker && t.kind != tokenLinkClose {
+			continue
+		}
+
+		opener := t.marker
+		if t.kind == tokenLinkClose {
+			opener = '['
+		}
+		if t.canClose {
+			for j := len(stack) - 1; j >= 0; j-- {
+				if tokens[stack[j]].marker != opener {
+					continue
+				}
+				if t.kind == tokenLinkClose && lastLink > stack[j] {
+					// The brackets of the outer link are kept as text
+					stack = append(stack[:j], stack[j+1:]...)
+					break
+				}
+				t.match, tokens[stack[j]].match = stack[j], i
+				stack = stack[:j]
+				if t.kind == tokenLinkClose {
+					lastLink = i
+				}
+				break
+			}
+			if t.match != -1 {
+				continue
+			}
+		}
+		if t.canOpen && t.kind == tokenMarker {
+			stack = append(stack, i)
+		}
+	}
+}
+
+// dropURLsInLinks removes the web addresses written in the text of links, which are links already
+func dropURLsInLinks(entities []Entity) []Entity {
+	kept := entities[:0]
+	linkEnd := -1
+	for _, e := range entities {
+		if e.Type == URL && e.Offset < linkEnd {
+			continue
+		}
+		if e.Type == Link {
+			linkEnd = e.Offset + e.Length
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// urlLength returns the length of the web address at the start of rs, 0 if there's none. Addresses end at spaces and
+// square brackets (so that they can be the text of links); trailing punctuation is not part of the address, nor are
+// closing parentheses without an opening one
+func urlLength(rs []rune) int {
+	s := strings.ToLower(string(rs[:min(len(rs), len("https://"))]))
+	var scheme int
+	switch {
+	case strings.HasPrefix(s, "https://"):
+		scheme = len("https://")
+	case strings.HasPrefix(s, "http://"):
+		scheme = len("http://")
+	default:
+		return 0
+	}
+
+	n := scheme
+	for n < len(rs) && !unicode.IsSpace(rs[n]) && rs[n] != '[' && rs[n] != ']' {
+		n++
+	}
+	for n > scheme {
+		c := rs[n-1]
+		if strings.ContainsRune(".,;:!?'\"]*_", c) ||
+			c == ')' && strings.Count(string(rs[:n]), "(") < strings.Count(string(rs[:n]), ")") {
+			n--
+			continue
+		}
+		break
+	}
+	if n == scheme || !validLink(string(rs[:n])) {
+		return 0
+	}
+	return n
+}
+
+// validLink checks the address of a link
+func validLink(address string) bool {
+	u, err := url.Parse(address)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+// atomic reports whether entities of the type can't contain other entities
+func atomic(entityType string) bool {
+	return entityType == Code || entityType == URL
+}
+
+// isWordRune reports whether the rune is a letter or a digit
+func isWordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// indexRune returns the index of the first c in rs at or after from, -1 if there's none
+func indexRune(rs []rune, c rune, from int) int {
+	for i := from; i < len(rs); i++ {
+		if rs[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
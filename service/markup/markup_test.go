@@ -0,0 +1,146 @@
+package markup
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKeepsInvalidLinksAsText(t *testing.T) {
+	for _, raw := range []string{"call f[0](arg) now", "see [docs](./readme)", "[x](javascript:alert(1))"} {
+		text, entities, err := Parse(raw)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", raw, err)
+			continue
+		}
+		if text != raw || len(entities) != 0 {
+			t.Errorf("Parse(%q) = %q, %v; want the text unchanged", raw, text, entities)
+		}
+	}
+}
+
+func TestParseLink(t *testing.T) {
+	text, entities, err := Parse("see [docs](https://example.com/docs)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Entity{Type: Link, Offset: 4, Length: 4, URL: "https://example.com/docs"}
+	if text != "see docs" || len(entities) != 1 || entities[0] != want {
+		t.Errorf("got %q, %v; want %q, [%v]", text, entities, "see docs", want)
+	}
+}
+
+func TestValidateRejectsInvalidLink(t *testing.T) {
+	err := Validate("see docs", []Entity{{Type: Link, Offset: 4, Length: 4, URL: "./readme"}})
+	if err != ErrInvalidLink {
+		t.Errorf("got %v, want ErrInvalidLink", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		raw      string
+		text     string
+		entities []Entity
+	}{
+		// Bold and italic open at the start of a word and close at its end
+		{"*bold* text", "bold text", []Entity{{Type: Bold, Offset: 0, Length: 4}}},
+		{"an _italic_ word", "an italic word", []Entity{{Type: Italic, Offset: 3, Length: 6}}},
+		{"*bold _both_*", "bold both", []Entity{{Type: Bold, Offset: 0, Length: 9}, {Type: Italic, Offset: 5, Length: 4}}},
+		{"snake_case_name", "snake_case_name", nil},
+		{"2*3*4", "2*3*4", nil},
+		{"* not bold *", "* not bold *", nil},
+		{"*unclosed", "*unclosed", nil},
+		{"**", "**", nil},
+		// Escapes
+		{`\*not bold\*`, "*not bold*", nil},
+		{`a \\ b`, `a \ b`, nil},
+		{`\[x](https://example.com)`, "[x](https://example.com)", nil},
+		// Code spans
+		{"run `*code*` now", "run *code* now", []Entity{{Type: Code, Offset: 4, Length: 6}}},
+		{"a ` alone", "a ` alone", nil},
+		{"``", "``", nil},
+		// Web addresses, without the trailing punctuation
+		{"see https://example.com/a.", "see https://example.com/a.", []Entity{{Type: URL, Offset: 4, Length: 21}}},
+		{"(https://example.com/x)", "(https://example.com/x)", []Entity{{Type: URL, Offset: 1, Length: 21}}},
+		{"https://example.com/f(x)", "https://example.com/f(x)", []Entity{{Type: URL, Offset: 0, Length: 24}}},
+		{"ftp://example.com", "ftp://example.com", nil},
+		{"[https://example.com](https://example.org)", "https://example.com",
+			[]Entity{{Type: Link, Offset: 0, Length: 19, URL: "https://example.org"}}},
+		// Offsets in UTF-16 code units
+		{"😀 *hi*", "😀 hi", []Entity{{Type: Bold, Offset: 3, Length: 2}}},
+		{"è _😀_", "è 😀", []Entity{{Type: Italic, Offset: 2, Length: 2}}},
+	} {
+		text, entities, err := Parse(tc.raw)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tc.raw, err)
+			continue
+		}
+		if text != tc.text || len(entities) != len(tc.entities) || len(entities) > 0 && !reflect.DeepEqual(entities, tc.entities) {
+			t.Errorf("Parse(%q) = %q, %v; want %q, %v", tc.raw, text, entities, tc.text, tc.entities)
+		}
+	}
+}
+
+func TestParseKeepsNestedLinksAsText(t *testing.T) {
+	text, entities, err := Parse("[a [b](http://x)](http://y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entity{{Type: Link, Offset: 3, Length: 1, URL: "http://x"}}
+	if text != "[a b](http://y)" || !reflect.DeepEqual(entities, want) {
+		t.Errorf("got %q, %v; want %q, %v", text, entities, "[a b](http://y)", want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	errInvalid := errors.New("any error")
+	tooMany := make([]Entity, MaxEntities+1)
+	for i := range tooMany {
+		tooMany[i] = Entity{Type: Bold, Offset: 0, Length: 1}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		entities []Entity
+		want     error
+	}{
+		{"nested", []Entity{{Type: Bold, Offset: 0, Length: 6}, {Type: Italic, Offset: 0, Length: 3}}, nil},
+		{"link with code", []Entity{{Type: Link, Offset: 0, Length: 6, URL: "mailto:a@example.com"},
+			{Type: Code, Offset: 2, Length: 2}}, nil},
+		{"crossing", []Entity{{Type: Bold, Offset: 0, Length: 4}, {Type: Italic, Offset: 2, Length: 4}}, errInvalid},
+		{"inside code", []Entity{{Type: Code, Offset: 0, Length: 4}, {Type: Bold, Offset: 1, Length: 2}}, errInvalid},
+		{"inside url", []Entity{{Type: URL, Offset: 0, Length: 4}, {Type: Italic, Offset: 0, Length: 4}}, errInvalid},
+		{"nested links", []Entity{{Type: Link, Offset: 0, Length: 4, URL: "http://a"},
+			{Type: Link, Offset: 1, Length: 2, URL: "http://b"}}, ErrNestedLink},
+		{"not sorted", []Entity{{Type: Bold, Offset: 2, Length: 2}, {Type: Italic, Offset: 0, Length: 2}}, errInvalid},
+		{"inner first", []Entity{{Type: Bold, Offset: 0, Length: 2}, {Type: Italic, Offset: 0, Length: 4}}, errInvalid},
+		{"out of the text", []Entity{{Type: Bold, Offset: 4, Length: 3}}, errInvalid},
+		{"empty", []Entity{{Type: Bold, Offset: 1, Length: 0}}, errInvalid},
+		{"unknown type", []Entity{{Type: "underline", Offset: 0, Length: 1}}, errInvalid},
+		{"address of bold", []Entity{{Type: Bold, Offset: 0, Length: 1, URL: "http://a"}}, errInvalid},
+		{"invalid link", []Entity{{Type: Link, Offset: 0, Length: 1, URL: "javascript:alert(1)"}}, ErrInvalidLink},
+		{"too many", tooMany, ErrTooManyEntities},
+	} {
+		err := Validate("abcdef", tc.entities)
+		switch {
+		case tc.want == nil && err != nil:
+			t.Errorf("%s: %v, want no error", tc.name, err)
+		case tc.want == errInvalid && err == nil:
+			t.Errorf("%s: no error", tc.name)
+		case tc.want != nil && tc.want != errInvalid && !errors.Is(err, tc.want):
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestValidateCountsUTF16(t *testing.T) {
+	text := strings.Repeat("😀", 2)
+	if err := Validate(text, []Entity{{Type: Bold, Offset: 0, Length: 4}}); err != nil {
+		t.Errorf("entity over two emoji: %v", err)
+	}
+	if err := Validate(text, []Entity{{Type: Bold, Offset: 0, Length: 5}}); err == nil {
+		t.Error("entity past the end: no error")
+	}
+}
@@ -0,0 +1,380 @@
+/*
+Package metrics is a tiny collector for application metrics, exposed in the Prometheus text exposition format.
+
+Metrics are created with NewCounterVec, NewGauge, NewGaugeFunc and NewHistogramVec and are registered in the
+DefaultRegistry, which is served by Handler(). Each metric name can be registered only once: creating two metrics with
+the same name panics, as it is a programming error.
+
+Example:
+
+	var loginCount = metrics.NewCounterVec("wasatext_logins_total", "Number of logins.", "result")
+
+	// ... later, in a handler
+	loginCount.Inc("ok")
+*/
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets (in seconds), suited for HTTP request latencies
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is implemented by each metric kind; writeTo appends the metric in text format to the buffer
+type collector interface {
+	metricName() string
+	writeTo(b *bytes.Buffer)
+}
+
+// Registry is a set of metrics which are exported together
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// DefaultRegistry is the registry where all metrics created by this package are registered
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register adds the collector to the registry. It panics if the name is already used
+func (reg *Registry) register(c collector) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.collectors[c.metricName()]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric name %q", c.metricName()))
+	}
+	reg.collectors[c.metricName()] = c
+}
+
+// WriteText writes all metrics in the registry using the Prometheus text format, sorted by name
+func (reg *Registry) WriteText(b *bytes.Buffer) {
+	reg.mu.Lock()
+	var names []string
+	for name := range reg.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var list []collector
+	for _, name := range names {
+		list = append(list, reg.collectors[name])
+	}
+	reg.mu.Unlock()
+
+	for _, c := range list {
+		c.writeTo(b)
+	}
+}
+
+// Handler returns an HTTP handler serving the metrics of the DefaultRegistry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b bytes.Buffer
+		DefaultRegistry.WriteText(&b)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(b.Bytes())
+	})
+}
+
+// CounterVec is a set of monotonic counters partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounterVec creates and registers a counter with the given label names
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]*counterValue),
+	}
+	DefaultRegistry.register(c)
+	return c
+}
+
+// Inc increments by one the counter identified by the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v (which must be non-negative) to the counter identified by the label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	checkLabels(c.name, c.labels, labelValues)
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+func (c *CounterVec) metricName() string {
+	return c.name
+}
+
+func (c *CounterVec) writeTo(b *bytes.Buffer) {
+	writeHeader(b, c.name, c.help, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		cv := c.values[key]
+		writeSample(b, c.name, c.labels, cv.labelValues, "", "", cv.value)
+	}
+}
+
+// Gauge is a single value which can go up and down
+type Gauge struct {
+	name string
+	help string
+
+	mu    sync.Mutex
+	value float64
+}
+
+// NewGauge creates and registers a gauge
+func NewGauge(name string, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	DefaultRegistry.register(g)
+	return g
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+// Add adds v (which can be negative) to the gauge
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) metricName() string {
+	return g.name
+}
+
+func (g *Gauge) writeTo(b *bytes.Buffer) {
+	g.mu.Lock()
+	v := g.value
+	g.mu.Unlock()
+
+	writeHeader(b, g.name, g.help, "gauge")
+	writeSample(b, g.name, nil, nil, "", "", v)
+}
+
+// gaugeFunc is a gauge whose value is computed when metrics are collected
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge whose value is returned by fn at collection time
+func NewGaugeFunc(name string, help string, fn func() float64) {
+	DefaultRegistry.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) metricName() string {
+	return g.name
+}
+
+func (g *gaugeFunc) writeTo(b *bytes.Buffer) {
+	writeHeader(b, g.name, g.help, "gauge")
+	writeSample(b, g.name, nil, nil, "", "", g.fn())
+}
+
+// HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec creates and registers a histogram with the given buckets (upper bounds, in increasing order) and
+// label names. If buckets is nil, DefaultBuckets is used
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets for %q are not sorted", name))
+	}
+	h := &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	DefaultRegistry.register(h)
+	return h
+}
+
+// Observe adds a single observation to the histogram identified by the label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	checkLabels(h.name, h.labels, labelValues)
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = hv
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *HistogramVec) metricName() string {
+	return h.name
+}
+
+func (h *HistogramVec) writeTo(b *bytes.Buffer) {
+	writeHeader(b, h.name, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		hv := h.values[key]
+		for i, upper := range h.buckets {
+			writeSample(b, h.name+"_bucket", h.labels, hv.labelValues, "le", formatFloat(upper), float64(hv.counts[i]))
+		}
+		writeSample(b, h.name+"_bucket", h.labels, hv.labelValues, "le", "+Inf", float64(hv.count))
+		writeSample(b, h.name+"_sum", h.labels, hv.labelValues, "", "", hv.sum)
+		writeSample(b, h.name+"_count", h.labels, hv.labelValues, "", "", float64(hv.count))
+	}
+}
+
+// checkLabels panics if the number of label values does not match the label names
+func checkLabels(name string, labels []string, values []string) {
+	if len(labels) != len(values) {
+		panic(fmt.Sprintf("metrics: %q expects %d label values, got %d", name, len(labels), len(values)))
+	}
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]*counterValue:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]*histogramValue:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeHeader(b *bytes.Buffer, name string, help string, kind string) {
+	_, _ = fmt.Fprintf(b, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	_, _ = fmt.Fprintf(b, "# TYPE %s %s\n", name, kind)
+}
+
+// writeSample writes a single sample line. extraName/extraValue is an additional label (e.g., "le" for buckets)
+func writeSample(b *bytes.Buffer, name string, labels []string, values []string, extraName string, extraValue string, v float64) {
+	b.WriteString(name)
+	if len(labels) > 0 || extraName != "" {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeLabel(b, label, values[i])
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			writeLabel(b, extraName, extraValue)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(v))
+	b.WriteByte('\n')
+}
+
+func writeLabel(b *bytes.Buffer, name string, value string) {
+	b.WriteString(name)
+	b.WriteString(`="`)
+	b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+	b.WriteByte('"')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
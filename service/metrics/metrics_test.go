@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// The metrics are registered in DefaultRegistry once, since names can't be registered twice
+var (
+	testCounter = NewCounterVec("test_requests_total", "Number of requests.\nWith a \\ in the help.", "route", "status")
+	testGauge   = NewGauge("test_connections", "Open connections.")
+	testHist    = NewHistogramVec("test_duration_seconds", "Request duration.", []float64{.1, 1}, "route")
+)
+
+func init() {
+	NewGaugeFunc("test_goroutines", "Running goroutines.", func() float64 { return math.Inf(1) })
+}
+
+func TestWriteText(t *testing.T) {
+	testCounter.Inc("/chats", "200")
+	testCounter.Add(2.5, "/chats", "200")
+	testCounter.Inc("/a\"b\\c\nd", "500")
+	testCounter.Add(-1, "/chats", "200") // ignored: counters can't decrease
+
+	testGauge.Inc()
+	testGauge.Add(2)
+	testGauge.Dec()
+
+	testHist.Observe(0.05, "/chats")
+	testHist.Observe(0.1, "/chats")
+	testHist.Observe(0.5, "/chats")
+	testHist.Observe(3, "/chats")
+
+	var b bytes.Buffer
+	DefaultRegistry.WriteText(&b)
+
+	golden := filepath.Join("testdata", "metrics.golden")
+	if *update {
+		if err := os.WriteFile(golden, b.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), want) {
+		t.Errorf("WriteText output differs from %s\ngot:\n%s\nwant:\n%s", golden, b.Bytes(), want)
+	}
+}
+
+func TestDuplicateNamePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice didn't panic")
+		}
+	}()
+	NewGauge("test_connections", "Again.")
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a wrong number of label values didn't panic")
+		}
+	}()
+	testCounter.Inc("/chats")
+}
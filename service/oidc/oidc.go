@@ -0,0 +1,325 @@
+/*
+Package oidc is a minimal OpenID Connect relying party, implementing the authorization code flow with PKCE (RFC 7636).
+
+The provider metadata is loaded from the issuer discovery document (/.well-known/openid-configuration) on first use.
+The ID token is received directly from the token endpoint over a TLS connection, so its signature is not verified (as
+allowed by OpenID Connect Core 1.0, section 3.1.3.7): issuer, audience, expiration and nonce are checked instead. This
+relies on TLS, so the issuer and the endpoints of the discovery document must be https URLs.
+
+Example:
+
+	provider, err := oidc.New(oidc.Config{Issuer: "https://idp.example.com", ClientID: "wasatext", RedirectURL: "..."})
+
+	// Login: redirect the browser to the provider
+	auth, err := provider.NewAuthRequest(ctx)
+	// ... store auth.State, auth.Nonce and auth.Verifier, then redirect to auth.URL
+
+	// Callback: exchange the code for the identity
+	identity, err := provider.Exchange(ctx, code, verifier, nonce)
+*/
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// Config is the relying party configuration
+type Config struct {
+	// Issuer is the provider issuer URL (https), used for discovery and to validate ID tokens
+	Issuer string
+
+	// ClientID and ClientSecret are the client credentials. The secret is optional for public clients
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the callback URL registered on the provider
+	RedirectURL string
+
+	// Scopes requested to the provider. "openid" is always added
+	Scopes []string
+
+	// HTTPClient is used for requests to the provider. If nil, a client with a 10 seconds timeout is used
+	HTTPClient *http.Client
+}
+
+// Provider is an OpenID Connect provider
+type Provider struct {
+	cfg Config
+
+	mu       sync.Mutex
+	metadata *metadata
+}
+
+// metadata is the subset of the discovery document used here
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// AuthRequest is a pending authorization request. State, Nonce and Verifier must be kept (server side) until the
+// callback is received
+type AuthRequest struct {
+	URL      string
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// Identity is the authenticated user, as described by the ID token claims
+type Identity struct {
+	Issuer            string `json:"iss"`
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Email             string `json:"email"`
+}
+
+// idTokenClaims are the ID token claims validated by Exchange
+type idTokenClaims struct {
+	Identity
+	Audience  audience `json:"aud"`
+	AZP       string   `json:"azp"`
+	ExpiresAt int64    `json:"exp"`
+	Nonce     string   `json:"nonce"`
+}
+
+// audience is the "aud" claim, which can be either a string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// ErrInsecureURL is returned when the issuer, or an endpoint of its discovery document, is not an https URL
+var ErrInsecureURL = errors.New("OpenID Connect URLs must use https")
+
+// New returns a provider for the configuration. No request is made until the provider is used
+func New(cfg Config) (*Provider, error) {
+	if !secureURL(cfg.Issuer) {
+		return nil, fmt.Errorf("issuer %q: %w", cfg.Issuer, ErrInsecureURL)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &Provider{cfg: cfg}, nil
+}
+
+// secureURL reports whether the URL is an absolute https URL
+func secureURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+// discover returns the provider metadata, loading the discovery document if needed. Failures are not cached, so a
+// provider temporarily unavailable does not break logins forever
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching discovery document: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching discovery document: unexpected status %s", res.Status)
+	}
+
+	var md metadata
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&md); err != nil {
+		return nil, fmt.Errorf("decoding discovery document: %w", err)
+	}
+	if strings.TrimSuffix(md.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", md.Issuer, p.cfg.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" {
+		return nil, errors.New("discovery document is missing the authorization or token endpoint")
+	}
+	if !secureURL(md.AuthorizationEndpoint) || !secureURL(md.TokenEndpoint) {
+		return nil, fmt.Errorf("discovery document endpoints: %w", ErrInsecureURL)
+	}
+
+	p.metadata = &md
+	return p.metadata, nil
+}
+
+// NewAuthRequest creates a new authorization request, with random state, nonce and PKCE verifier
+func (p *Provider) NewAuthRequest(ctx context.Context) (AuthRequest, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return AuthRequest{}, err
+	}
+
+	var auth AuthRequest
+	for _, field := range []*string{&auth.State, &auth.Nonce, &auth.Verifier} {
+		if *field, err = randomString(); err != nil {
+			return AuthRequest{}, err
+		}
+	}
+
+	scopes := []string{"openid"}
+	for _, scope := range p.cfg.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	challenge := sha256.Sum256([]byte(auth.Verifier))
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(scopes, " "))
+	v.Set("state", auth.State)
+	v.Set("nonce", auth.Nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	auth.URL = md.AuthorizationEndpoint + sep + v.Encode()
+	return auth, nil
+}
+
+// Exchange redeems the authorization code at the token endpoint and returns the identity in the ID token, after
+// validating issuer, audience, expiration and nonce
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Identity, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.cfg.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	res, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("calling token endpoint: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("token endpoint: unexpected status %s", res.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tokens); err != nil {
+		return Identity{}, fmt.Errorf("decoding token response: %w", err)
+	}
+
+	claims, err := parseIDToken(tokens.IDToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if err := p.validate(claims, nonce); err != nil {
+		return Identity{}, err
+	}
+	return claims.Identity, nil
+}
+
+// validate checks the ID token claims (OpenID Connect Core 1.0, section 3.1.3.7)
+func (p *Provider) validate(claims idTokenClaims, nonce string) error {
+	if strings.TrimSuffix(claims.Issuer, "/") != p.cfg.Issuer {
+		return fmt.Errorf("ID token issuer %q does not match", claims.Issuer)
+	}
+
+	found := false
+	for _, aud := range claims.Audience {
+		if aud == p.cfg.ClientID {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("ID token audience does not include the client ID")
+	}
+	if len(claims.Audience) > 1 && claims.AZP != "" && claims.AZP != p.cfg.ClientID {
+		return errors.New("ID token authorized party is not the client ID")
+	}
+
+	if claims.ExpiresAt == 0 || !globaltime.Now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return errors.New("ID token expired")
+	}
+	if claims.Nonce != nonce {
+		return errors.New("ID token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return errors.New("ID token has no subject")
+	}
+	return nil
+}
+
+// parseIDToken decodes the claims of a JWT, without verifying its signature (see the package documentation)
+func parseIDToken(token string) (idTokenClaims, error) {
+	var claims idTokenClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return claims, fmt.Errorf("decoding ID token payload: %w", err)
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("decoding ID token claims: %w", err)
+	}
+	return claims, nil
+}
+
+// randomString returns 32 random bytes, base64url encoded (43 characters, as required for PKCE verifiers)
+func randomString() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
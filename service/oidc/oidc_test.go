@@ -0,0 +1,232 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+const (
+	testClientID    = "wasatext"
+	testRedirectURL = "https://wasatext.example.com/session/oidc/callback"
+	testCode        = "the-code"
+)
+
+// stubProvider is an identity provider serving the discovery document and the token endpoint. The token endpoint
+// checks the PKCE verifier against the challenge of the last authorization request, and returns an ID token with
+// the claims set by the test
+type stubProvider struct {
+	t      *testing.T
+	server *httptest.Server
+
+	// discovery overrides fields of the discovery document
+	discovery map[string]string
+
+	// challenge is the code_challenge of the authorization request
+	challenge string
+
+	// claims are returned in the ID token; "iss", "aud", "exp" and "nonce" are set by newStubProvider and login
+	claims map[string]interface{}
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	t.Helper()
+	s := &stubProvider{t: t, discovery: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		doc := map[string]string{
+			"issuer":                 s.server.URL,
+			"authorization_endpoint": s.server.URL + "/authorize",
+			"token_endpoint":         s.server.URL + "/token",
+		}
+		for k, v := range s.discovery {
+			doc[k] = v
+		}
+		_ = json.NewEncoder(w).Encode(doc)
+	})
+	mux.HandleFunc("/token", s.token)
+	s.server = httptest.NewTLSServer(mux)
+	t.Cleanup(s.server.Close)
+
+	s.claims = map[string]interface{}{
+		"iss":                s.server.URL,
+		"sub":                "subject-1",
+		"aud":                testClientID,
+		"exp":                globaltime.Now().Add(time.Minute).Unix(),
+		"preferred_username": "alice",
+	}
+	return s
+}
+
+func (s *stubProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != testCode ||
+		r.PostForm.Get("redirect_uri") != testRedirectURL || r.PostForm.Get("client_id") != testClientID {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != s.challenge {
+		http.Error(w, "invalid_grant: PKCE verification failed", http.StatusBadRequest)
+		return
+	}
+
+	payload, err := json.Marshal(s.claims)
+	if err != nil {
+		s.t.Error(err)
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	idToken := header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+	_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// provider returns a relying party for the stub
+func (s *stubProvider) provider() *Provider {
+	s.t.Helper()
+	p, err := New(Config{
+		Issuer:      s.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+		HTTPClient:  s.server.Client(),
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return p
+}
+
+// login runs the whole flow: the authorization request, then the code exchange with the nonce set in the ID token
+func (s *stubProvider) login(p *Provider, tokenNonce func(requestNonce string) string) (Identity, error) {
+	s.t.Helper()
+	auth, err := p.NewAuthRequest(context.Background())
+	if err != nil {
+		return Identity{}, err
+	}
+
+	u, err := url.Parse(auth.URL)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("state") != auth.State ||
+		query.Get("nonce") != auth.Nonce || query.Get("client_id") != testClientID {
+		s.t.Fatalf("unexpected authorization request %s", auth.URL)
+	}
+	s.challenge = query.Get("code_challenge")
+
+	s.claims["nonce"] = tokenNonce(auth.Nonce)
+	return p.Exchange(context.Background(), testCode, auth.Verifier, auth.Nonce)
+}
+
+func sameNonce(nonce string) string { return nonce }
+
+func TestExchange(t *testing.T) {
+	s := newStubProvider(t)
+	identity, err := s.login(s.provider(), sameNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Issuer != s.server.URL || identity.Subject != "subject-1" || identity.PreferredUsername != "alice" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	s := newStubProvider(t)
+	p := s.provider()
+	auth, err := p.NewAuthRequest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(auth.URL)
+	s.challenge = u.Query().Get("code_challenge")
+	s.claims["nonce"] = auth.Nonce
+
+	if _, err := p.Exchange(context.Background(), testCode, "another-verifier", auth.Nonce); err == nil {
+		t.Error("the code was exchanged with a wrong PKCE verifier")
+	}
+}
+
+func TestExchangeRejectsInvalidClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		nonce  func(string) string
+	}{
+		{name: "issuer", claims: map[string]interface{}{"iss": "https://evil.example.com"}},
+		{name: "audience", claims: map[string]interface{}{"aud": "another-client"}},
+		{name: "audience list", claims: map[string]interface{}{"aud": []string{"another-client", "third-client"}}},
+		{name: "authorized party", claims: map[string]interface{}{"aud": []string{testClientID, "another-client"}, "azp": "another-client"}},
+		{name: "expired", claims: map[string]interface{}{"exp": globaltime.Now().Add(-time.Second).Unix()}},
+		{name: "no expiration", claims: map[string]interface{}{"exp": 0}},
+		{name: "subject", claims: map[string]interface{}{"sub": ""}},
+		{name: "nonce", nonce: func(string) string { return "another-nonce" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStubProvider(t)
+			for k, v := range tt.claims {
+				s.claims[k] = v
+			}
+			nonce := tt.nonce
+			if nonce == nil {
+				nonce = sameNonce
+			}
+			if identity, err := s.login(s.provider(), nonce); err == nil {
+				t.Errorf("invalid ID token accepted: %+v", identity)
+			}
+		})
+	}
+}
+
+func TestExchangeExpiredWithFixedTime(t *testing.T) {
+	s := newStubProvider(t)
+	globaltime.FixedTime = time.Unix(s.claims["exp"].(int64), 0)
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	if _, err := s.login(s.provider(), sameNonce); err == nil {
+		t.Error("ID token accepted at its expiration time")
+	}
+}
+
+func TestDiscoveryRejectsWrongIssuer(t *testing.T) {
+	s := newStubProvider(t)
+	s.discovery["issuer"] = "https://evil.example.com"
+	if _, err := s.provider().NewAuthRequest(context.Background()); err == nil {
+		t.Error("discovery document with a different issuer accepted")
+	}
+}
+
+func TestDiscoveryRejectsInsecureEndpoints(t *testing.T) {
+	for _, field := range []string{"authorization_endpoint", "token_endpoint"} {
+		t.Run(field, func(t *testing.T) {
+			s := newStubProvider(t)
+			s.discovery[field] = "http://idp.example.com/" + field
+			_, err := s.provider().NewAuthRequest(context.Background())
+			if !errors.Is(err, ErrInsecureURL) {
+				t.Errorf("got %v, want ErrInsecureURL", err)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInsecureIssuer(t *testing.T) {
+	for _, issuer := range []string{"http://idp.example.com", "idp.example.com", "https://"} {
+		if _, err := New(Config{Issuer: issuer, ClientID: testClientID}); !errors.Is(err, ErrInsecureURL) {
+			t.Errorf("New(%q): got %v, want ErrInsecureURL", issuer, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Package ratelimit implements an in-memory token bucket rate limiter. Each key (e.g., a client IP address or a user ID)
+has its own bucket, which holds up to `burst` tokens and is refilled at a constant rate. Each request consumes a token,
+and it's rejected when the bucket is empty.
+
+Buckets are kept in memory, so limits are enforced per instance: this is fine as long as only one instance of the
+server is running. Time is read from the globaltime package, so that tests can move it forward.
+
+Example:
+
+	limiter := ratelimit.New(60, 10) // 60 requests per minute, bursts up to 10 requests
+
+	if ok, retryAfter := limiter.Allow("ip:" + ip, "user:" + userId); !ok {
+		// reject the request, the client can retry after `retryAfter`
+	}
+
+Buckets can also count only some events: Take consumes a token after the event (e.g., a failed login), and Wait
+returns how long the next one should be held back.
+*/
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// Limiter is a set of token buckets, one for each key
+type Limiter struct {
+	// rate is the number of tokens added each second
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	allowed uint64
+	limited uint64
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a new limiter which allows `perMinute` requests per minute for each key, with bursts up to `burst`
+// requests
+func New(perMinute float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// refill returns the bucket for the key, with tokens added for the time passed since the last update
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		return b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+	return b
+}
+
+// Allow consumes a token from the bucket of each key. If any of the buckets is empty, no token is consumed and the
+// request should be rejected: the returned duration is the time to wait before a token is available in every bucket.
+func (l *Limiter) Allow(keys ...string) (bool, time.Duration) {
+	now := globaltime.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait float64
+	var list []*bucket
+	for _, key := range keys {
+		b := l.refill(key, now)
+		if b.tokens < 1 {
+			if l.rate <= 0 {
+				wait = math.Inf(1)
+			} else {
+				wait = math.Max(wait, (1-b.tokens)/l.rate)
+			}
+		}
+		list = append(list, b)
+	}
+
+	if wait > 0 {
+		l.limited++
+		if math.IsInf(wait, 1) {
+			return false, time.Duration(math.MaxInt64)
+		}
+		return false, time.Duration(wait * float64(time.Second))
+	}
+
+	for _, b := range list {
+		b.tokens--
+	}
+	l.allowed++
+	return true, 0
+}
+
+// Take consumes a token from the bucket of the key, if there's one left
+func (l *Limiter) Take(key string) {
+	now := globaltime.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b := l.refill(key, now); b.tokens >= 1 {
+		b.tokens--
+	}
+}
+
+// Wait returns the time to wait before a token is available in the bucket of the key, 0 if there's one already. No
+// token is consumed
+func (l *Limiter) Wait(key string) time.Duration {
+	now := globaltime.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, now)
+	switch {
+	case b.tokens >= 1:
+		return 0
+	case l.rate <= 0:
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// Cleanup removes buckets which are full again, as they are equivalent to new buckets. It should be called
+// periodically to free the memory used by clients which are gone.
+func (l *Limiter) Cleanup() {
+	now := globaltime.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key := range l.buckets {
+		if b := l.refill(key, now); b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Stats returns the number of allowed and limited requests since the last call to Stats, and the number of buckets
+// currently in memory
+func (l *Limiter) Stats() (allowed uint64, limited uint64, buckets int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	allowed, limited = l.allowed, l.limited
+	l.allowed, l.limited = 0, 0
+	return allowed, limited, len(l.buckets)
+}
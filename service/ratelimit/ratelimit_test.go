@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// setTime fixes the clock at t until the end of the test
+func setTime(t *testing.T, now time.Time) {
+	t.Helper()
+	globaltime.FixedTime = now
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+}
+
+var start = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestAllowBurstAndRefill(t *testing.T) {
+	setTime(t, start)
+	l := New(60, 3) // one token each second
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("ip:a"); !ok {
+			t.Fatalf("request %d of the burst rejected", i+1)
+		}
+	}
+	ok, retryAfter := l.Allow("ip:a")
+	if ok || retryAfter != time.Second {
+		t.Errorf("Allow after the burst = %v, %v; want false, 1s", ok, retryAfter)
+	}
+
+	// Other keys have their own bucket
+	if ok, _ := l.Allow("ip:b"); !ok {
+		t.Error("another key rejected")
+	}
+
+	setTime(t, start.Add(500*time.Millisecond))
+	if ok, retryAfter := l.Allow("ip:a"); ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("Allow after half a second = %v, %v; want false, 500ms", ok, retryAfter)
+	}
+	setTime(t, start.Add(time.Second))
+	if ok, _ := l.Allow("ip:a"); !ok {
+		t.Error("request rejected after the refill")
+	}
+}
+
+func TestAllowAllKeys(t *testing.T) {
+	setTime(t, start)
+	l := New(60, 1)
+
+	if ok, _ := l.Allow("ip:a", "user:1"); !ok {
+		t.Fatal("first request rejected")
+	}
+	// The user bucket is empty: the request is rejected, and no token is taken from the new IP address
+	if ok, _ := l.Allow("ip:b", "user:1"); ok {
+		t.Error("request allowed with an empty bucket")
+	}
+	if ok, _ := l.Allow("ip:b"); !ok {
+		t.Error("token consumed from the bucket of a rejected request")
+	}
+}
+
+func TestAllowDisabledRate(t *testing.T) {
+	setTime(t, start)
+	l := New(0, 1)
+
+	l.Allow("ip:a")
+	if ok, retryAfter := l.Allow("ip:a"); ok || retryAfter <= 24*time.Hour {
+		t.Errorf("Allow = %v, %v; want false, forever", ok, retryAfter)
+	}
+}
+
+func TestTakeAndWait(t *testing.T) {
+	setTime(t, start)
+	l := New(30, 2) // one token every two seconds
+
+	if wait := l.Wait("username:alice"); wait != 0 {
+		t.Errorf("Wait on a new key = %v, want 0", wait)
+	}
+	l.Take("username:alice")
+	l.Take("username:alice")
+	l.Take("username:alice") // nothing left: ignored
+	if wait := l.Wait("username:alice"); wait != 2*time.Second {
+		t.Errorf("Wait after the burst = %v, want 2s", wait)
+	}
+	if wait := l.Wait("username:alice"); wait != 2*time.Second {
+		t.Errorf("Wait consumed a token: %v, want 2s", wait)
+	}
+
+	setTime(t, start.Add(2*time.Second))
+	if wait := l.Wait("username:alice"); wait != 0 {
+		t.Errorf("Wait after the refill = %v, want 0", wait)
+	}
+}
+
+func TestCleanupAndStats(t *testing.T) {
+	setTime(t, start)
+	l := New(60, 2)
+
+	l.Allow("ip:a")
+	l.Allow("ip:b")
+	l.Allow("ip:b")
+	l.Allow("ip:b")
+	if allowed, limited, buckets := l.Stats(); allowed != 3 || limited != 1 || buckets != 2 {
+		t.Errorf("Stats = %d, %d, %d; want 3, 1, 2", allowed, limited, buckets)
+	}
+	if allowed, limited, _ := l.Stats(); allowed != 0 || limited != 0 {
+		t.Errorf("Stats not reset: %d, %d", allowed, limited)
+	}
+
+	// After a second, the bucket of ip:a is full again and it's removed; ip:b still misses a token
+	setTime(t, start.Add(time.Second))
+	l.Cleanup()
+	if _, _, buckets := l.Stats(); buckets != 1 {
+		t.Errorf("buckets after Cleanup = %d, want 1", buckets)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Package realtime delivers events to the connected clients of each user (e.g., a new message, or a contact coming
+online). Clients subscribe with the ID of the last event they received: events published while they were disconnected
+are kept in a short per-user backlog, so that they are replayed when the client reconnects.
+
+Everything is kept in memory, so events are delivered only by the instance where they are published: this is fine as
+long as only one instance of the server is running. Time is read from the globaltime package.
+
+Example:
+
+	hub := realtime.New(256, 2*time.Minute)
+
+	// In the handler of the real-time channel
+	sub, missed := hub.Subscribe(userId, lastEventId)
+	defer sub.Close()
+	// ... send `missed`, then every event received from sub.Events() until it's closed
+
+	// Anywhere else
+	hub.Publish("message", payload, recipientIds...)
+*/
+package realtime
+
+import (
+	"sync"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// Event is an event sent to a user. IDs are increasing, and unique in the hub
+type Event struct {
+	ID   uint64
+	Type string
+	Data interface{}
+	At   time.Time
+}
+
+// subscriptionBuffer is the number of events buffered for each subscription. A client which doesn't keep up is
+// disconnected, and it receives the missed events from the backlog when it reconnects
+const subscriptionBuffer = 64
+
+// Hub dispatches events to the subscriptions of each user
+type Hub struct {
+	backlogSize int
+	backlogAge  time.Duration
+
+	mu     sync.Mutex
+	lastID uint64
+	users  map[int]*userState
+
+	// onConnect and onDisconnect are called (without holding the lock) when the first subscription of a user is opened
+	// and when the last one is closed
+	onConnect    func(userId int)
+	onDisconnect func(userId int)
+}
+
+type userState struct {
+	subscriptions map[*Subscription]struct{}
+	backlog       []Event
+}
+
+// Subscription is a client connection receiving the events of a user
+type Subscription struct {
+	hub    *Hub
+	userId int
+	events chan Event
+	once   sync.Once
+}
+
+// New creates a hub keeping up to backlogSize events, for at most backlogAge, for each user
+func New(backlogSize int, backlogAge time.Duration) *Hub {
+	return &Hub{
+		backlogSize: backlogSize,
+		backlogAge:  backlogAge,
+		users:       make(map[int]*userState),
+	}
+}
+
+// OnConnect sets a function called when a user, without other subscriptions, subscribes. It must be called before the
+// hub is used
+func (h *Hub) OnConnect(fn func(userId int)) {
+	h.onConnect = fn
+}
+
+// OnDisconnect sets a function called when the last subscription of a user is closed. It must be called before the
+// hub is used
+func (h *Hub) OnDisconnect(fn func(userId int)) {
+	h.onDisconnect = fn
+}
+
+// Subscribe opens a subscription for the user. The events in the backlog published after lastEventId are returned,
+// so that a reconnecting client doesn't miss them (lastEventId 0 means a new client, which receives no backlog).
+func (h *Hub) Subscribe(userId int, lastEventId uint64) (*Subscription, []Event) {
+	sub := &Subscription{hub: h, userId: userId, events: make(chan Event, subscriptionBuffer)}
+
+	h.mu.Lock()
+	user := h.user(userId)
+	first := len(user.subscriptions) == 0
+	user.subscriptions[sub] = struct{}{}
+
+	var missed []Event
+	if lastEventId > 0 {
+		for _, event := range user.backlog {
+			if event.ID > lastEventId {
+				missed = append(missed, event)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	if first && h.onConnect != nil {
+		h.onConnect(userId)
+	}
+	return sub, missed
+}
+
+// Publish sends an event to every subscription of the users, and adds it to their backlogs
+func (h *Hub) Publish(eventType string, data interface{}, userIds ...int) {
+	if len(userIds) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	h.lastID++
+	event := Event{ID: h.lastID, Type: eventType, Data: data, At: globaltime.Now()}
+
+	var slow []*Subscription
+	for _, userId := range userIds {
+		user := h.user(userId)
+		user.backlog = append(user.backlog, event)
+		if len(user.backlog) > h.backlogSize {
+			user.backlog = user.backlog[len(user.backlog)-h.backlogSize:]
+		}
+		for sub := range user.subscriptions {
+			select {
+			case sub.events <- event:
+			default:
+				slow = append(slow, sub)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	for _, sub := range slow {
+		sub.Close()
+	}
+}
+
+// Connected reports whether the user has at least one subscription
+func (h *Hub) Connected(userId int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	user, ok := h.users[userId]
+	return ok && len(user.subscriptions) > 0
+}
+
+// Connections returns the number of open subscriptions
+func (h *Hub) Connections() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, user := range h.users {
+		n += len(user.subscriptions)
+	}
+	return n
+}
+
+// Cleanup removes the expired events from the backlogs, and the users left without events and subscriptions. It
+// should be called periodically.
+func (h *Hub) Cleanup() {
+	cutoff := globaltime.Now().Add(-h.backlogAge)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for userId, user := range h.users {
+		i := 0
+		for i < len(user.backlog) && user.backlog[i].At.Before(cutoff) {
+			i++
+		}
+		user.backlog = user.backlog[i:]
+		if len(user.backlog) == 0 && len(user.subscriptions) == 0 {
+			delete(h.users, userId)
+		}
+	}
+}
+
+// user returns the state of the user, creating it if needed. The lock must be held
+func (h *Hub) user(userId int) *userState {
+	user, ok := h.users[userId]
+	if !ok {
+		user = &userState{subscriptions: make(map[*Subscription]struct{})}
+		h.users[userId] = user
+	}
+	return user
+}
+
+// Events returns the channel where events are received. It's closed when the subscription is closed, either by the
+// client or by the hub (when the client doesn't keep up)
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close closes the subscription. It can be called more than once
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		h := s.hub
+		h.mu.Lock()
+		user := h.users[s.userId]
+		delete(user.subscriptions, s)
+		last := len(user.subscriptions) == 0
+		close(s.events)
+		h.mu.Unlock()
+
+		if last && h.onDisconnect != nil {
+			h.onDisconnect(s.userId)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"testing"
+	"time"
+	"wasatext/service/globaltime"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors ("12345678901234567890"), base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// RFC 6238, appendix B (SHA1). The RFC uses 8 digits: the 6 digits codes are the last 6 digits
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, v := range vectors {
+		code, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", v.unix, err)
+		}
+		if code != v.code {
+			t.Errorf("Code(%d) = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err != ErrInvalidSecret {
+		t.Errorf("Code with an invalid secret: got %v, want ErrInvalidSecret", err)
+	}
+}
+
+func TestValidateStepWindow(t *testing.T) {
+	globaltime.FixedTime = time.Unix(1234567890, 0)
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	current := Step(globaltime.Now())
+	for offset := int64(-Skew - 1); offset <= Skew+1; offset++ {
+		code, err := Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, globaltime.Now(), 0)
+		inWindow := offset >= -Skew && offset <= Skew
+		if ok != inWindow {
+			t.Errorf("offset %d: valid = %v, want %v", offset, ok, inWindow)
+		}
+		if ok && step != current+offset {
+			t.Errorf("offset %d: step = %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestValidateRejectsReplay(t *testing.T) {
+	globaltime.FixedTime = time.Unix(1234567890, 0)
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	code, err := Code(rfcSecret, Step(globaltime.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, ok := Validate(rfcSecret, code, globaltime.Now(), 0)
+	if !ok {
+		t.Fatal("the current code is not valid")
+	}
+
+	// Once the step is saved as the last one used, the same code (and the older ones) are rejected
+	if _, ok := Validate(rfcSecret, code, globaltime.Now(), step); ok {
+		t.Error("the code was accepted twice")
+	}
+	previous, err := Code(rfcSecret, step-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(rfcSecret, previous, globaltime.Now(), step); ok {
+		t.Error("a code older than the last one used was accepted")
+	}
+}
+
+func TestValidateMalformedCode(t *testing.T) {
+	globaltime.FixedTime = time.Unix(1234567890, 0)
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, globaltime.Now(), 0); ok {
+			t.Errorf("code %q was accepted", code)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"regexp"
+	"unicode"
+	"unicode/utf8"
 )
 
 func ValidUsername(username string) bool {
@@ -11,3 +13,35 @@ func ValidUsername(username string) bool {
 	}
 	return false
 }
+
+// ValidPassphrase checks the passphrase length: between 8 and 72 bytes (the maximum supported by bcrypt)
+func ValidPassphrase(passphrase string) bool {
+	return len(passphrase) >= 8 && len(passphrase) <= 72
+}
+
+// SanitizeUsername turns an arbitrary name into a string following the ValidUsername rules: non alphanumeric
+// characters are removed, the result is truncated to 16 characters and padded with "user" if too short.
+func SanitizeUsername(name string) string {
+	username := regexp.MustCompile(`[^a-zA-Z0-9]+`).ReplaceAllString(name, "")
+	if len(username) > 16 {
+		username = username[:16]
+	}
+	if len(username) < 3 {
+		username = "user" + username
+	}
+	return username
+}
+
+// ValidProfileText checks a profile field (display name, bio, status): valid UTF-8, at most maxLength characters and
+// no control characters, except new lines when multiline is true
+func ValidProfileText(text string, maxLength int, multiline bool) bool {
+	if !utf8.ValidString(text) || utf8.RuneCountInString(text) > maxLength {
+		return false
+	}
+	for _, c := range text {
+		if unicode.IsControl(c) && !(multiline && c == '\n') {
+			return false
+		}
+	}
+	return true
+}